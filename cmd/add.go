@@ -5,9 +5,7 @@ Copyright © 2022 NAME HERE <EMAIL ADDRESS>
 package cmd
 
 import (
-	"fmt"
 	"log"
-	"os"
 	"path/filepath"
 
 	"github.com/spf13/cobra"
@@ -18,22 +16,112 @@ var addCmd = &cobra.Command{
 	Use:   "add",
 	Short: "Add a file|dir to the tasks list",
 	Long: `Add a file or a directory to the sync tasks list:
-"dsync add [file|dir]".`,
+"dsync add [file|dir] [--dest remote...] [--mirror trash|delete] [--two-way]
+[--conflict keep-both|local|remote|skip] [--snapshot]
+[--exclude pattern...] [--include pattern...] [--symlinks skip|follow|link]
+[--keep-hourly n] [--keep-daily n] [--keep-weekly n] [--keep-monthly n]
+[--shared-drive id|name] [--remote path]"
+A task can be synced to several remotes by repeating the "--dest" flag,
+e.g. "dsync add docs --dest drive --dest nas".
+Dotfiles, editor backups and the files matching the gitignore patterns of
+the ".dsyncignore" files and of "--exclude" aren't synced, "--include"
+patterns and "!pattern" lines sync them anyway, e.g.
+"dsync add project --exclude node_modules/ --exclude 'build/**' --include .env".
+Symbolic links are followed, unless "--symlinks" skips them or uploads
+them as links that restore recreates, links to a folder being synced are
+skipped.
+With "--mirror" remote files and folders whose local source is gone
+are moved to the remote trash or deleted, ignored files and the files of
+other tasks are kept.
+With "--two-way" files added, modified, moved or removed in the remote
+are applied locally before every sync, drive remotes ask for access to the
+whole drive as the changes made by other apps are out of reach otherwise.
+Files modified in the remote since their last sync are in conflict, the
+"--conflict" policy keeps both copies by saving the remote one with a
+".conflict-<remote>-<time>" suffix, overwrites it, replaces the local file with it
+or skips the file.
+With "--snapshot" every sync takes a dated snapshot of the task in the
+"<name>.snapshots" remote folder, files not modified since the last
+snapshot are listed in its manifest and not uploaded again.
+The "--keep-*" counts are the retention policy "dsync prune" applies to the
+task snapshots and file revisions, e.g. "--keep-daily 7 --keep-weekly 4"
+keeps a copy of each of the last 7 days and 4 weeks.
+With "--shared-drive" the task is stored in the root of the given shared
+drive instead of the user own drive, all the task remotes have to be drive
+remotes and they ask for access to the whole drive.
+With "--remote" the task is synced under the given remote folder path,
+e.g. "dsync add projects --remote Backups/laptop", missing folders are
+created on the next sync. The remote copy of a task already synced isn't
+moved when its remote path changes.
+If the file or directory is already in the list its options are updated.`,
 	Args: cobra.ExactArgs(1),
 	Run: func(cmd *cobra.Command, args []string) {
-		GetDriveService()
 		fileToAdd, err := filepath.Abs(args[0])
 		if err != nil {
 			log.Fatalf("Unable to get file or directory %q: %v", args[0], err)
 		}
-		listFile, err := os.OpenFile(TasksFile, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
-		if err != nil {
-			log.Fatalf("Unable to update/create tasks list file: %v", err)
+		task := Task{Path: fileToAdd}
+		task.Dests, _ = cmd.Flags().GetStringSlice("dest")
+		task.Mirror, _ = cmd.Flags().GetString("mirror")
+		task.TwoWay, _ = cmd.Flags().GetBool("two-way")
+		task.Conflict, _ = cmd.Flags().GetString("conflict")
+		task.Exclude, _ = cmd.Flags().GetStringSlice("exclude")
+		task.Include, _ = cmd.Flags().GetStringSlice("include")
+		task.Symlinks, _ = cmd.Flags().GetString("symlinks")
+		task.Snapshot, _ = cmd.Flags().GetBool("snapshot")
+		if !ValidSymlinks(task.Symlinks) {
+			log.Fatalf("Unknown symlink policy %q", task.Symlinks)
+		}
+		if !ValidConflict(task.Conflict) {
+			log.Fatalf("Unknown conflict policy %q", task.Conflict)
+		}
+		if !ValidMirror(task.Mirror) {
+			log.Fatalf("Unknown mirror policy %q", task.Mirror)
+		}
+		if task.Snapshot && (task.Mirror != "" || task.TwoWay || task.Conflict != "") {
+			log.Fatalf("Snapshots can't be taken with mirror, two-way or conflict policies")
+		}
+		retention := &Retention{}
+		retention.Hourly, _ = cmd.Flags().GetInt("keep-hourly")
+		retention.Daily, _ = cmd.Flags().GetInt("keep-daily")
+		retention.Weekly, _ = cmd.Flags().GetInt("keep-weekly")
+		retention.Monthly, _ = cmd.Flags().GetInt("keep-monthly")
+		if retention.Hourly < 0 || retention.Daily < 0 || retention.Weekly < 0 || retention.Monthly < 0 {
+			log.Fatalf("Retention counts can't be negative")
 		}
-		defer listFile.Close()
-		if _, err := fmt.Fprintf(listFile, "%v\n", fileToAdd); err != nil {
-			log.Fatalf("Unable to write to tasks list file: %v", err)
+		if !retention.IsZero() {
+			task.Retention = retention
+		}
+		task.SharedDrive, _ = cmd.Flags().GetString("shared-drive")
+		remotePath, _ := cmd.Flags().GetString("remote")
+		if task.RemotePath, err = CleanRemotePath(remotePath); err != nil {
+			log.Fatalf("Unable to add %q: %v", fileToAdd, err)
+		}
+		//check the remotes are usable, drive remotes ask for authorization
+		for _, remoteName := range task.Remotes() {
+			backend := GetBackend(GetRemote(remoteName), task.FullAccess())
+			if task.SharedDrive == "" {
+				continue
+			}
+			sharedDriver, ok := backend.(SharedDriver)
+			if !ok {
+				log.Fatalf("Remote %v can't store files in shared drives", remoteName)
+			}
+			_, root, err := sharedDriver.SharedDrive(task.SharedDrive)
+			if err != nil {
+				log.Fatalf("Unable to use shared drive %q of remote %v: %v", task.SharedDrive, remoteName, err)
+			}
+			//the Id keeps working if the shared drive is renamed
+			task.SharedDrive, task.SharedDriveName = root.ID, root.Name
+		}
+
+		var tasks []Task
+		for _, oldTask := range GetTasks() {
+			if oldTask.Path != task.Path {
+				tasks = append(tasks, oldTask)
+			}
 		}
+		SaveTasks(append(tasks, task))
 	},
 }
 
@@ -48,5 +136,18 @@ func init() {
 
 	// Cobra supports local flags which will only run when this command
 	// is called directly, e.g.:
-	// addCmd.Flags().BoolP("toggle", "t", false, "Help message for toggle")
+	addCmd.Flags().StringSliceP("dest", "d", nil, "Remotes to sync the task to, can be repeated (default [drive])")
+	addCmd.Flags().StringP("mirror", "m", "", "Remove remote files whose local source is gone: trash|delete")
+	addCmd.Flags().StringSliceP("exclude", "e", nil, "Gitignore pattern of files and folders not to sync, can be repeated")
+	addCmd.Flags().StringSliceP("include", "i", nil, "Gitignore pattern of files and folders to sync anyway, can be repeated")
+	addCmd.Flags().StringP("symlinks", "l", "", "Symbolic links policy: skip|follow|link (default follow)")
+	addCmd.Flags().StringP("conflict", "c", "", "Files modified both locally and remotely: keep-both|local|remote|skip (default keep-both)")
+	addCmd.Flags().Bool("two-way", false, "Download remote changes before syncing, drive remotes only")
+	addCmd.Flags().Bool("snapshot", false, "Take dated snapshots instead of updating the remote copy")
+	addCmd.Flags().Int("keep-hourly", 0, "Hourly copies kept by prune")
+	addCmd.Flags().Int("keep-daily", 0, "Daily copies kept by prune")
+	addCmd.Flags().Int("keep-weekly", 0, "Weekly copies kept by prune")
+	addCmd.Flags().Int("keep-monthly", 0, "Monthly copies kept by prune")
+	addCmd.Flags().String("shared-drive", "", "Id or name of the shared drive to store the task in, drive remotes only")
+	addCmd.Flags().String("remote", "", "Remote folder path to sync the task under (default the remote root)")
 }
@@ -5,6 +5,8 @@ Copyright © 2022 NAME HERE <EMAIL ADDRESS>
 package cmd
 
 import (
+	"encoding/json"
+	"errors"
 	"log"
 	"os"
 	"path"
@@ -16,13 +18,109 @@ import (
 //tasks list file used for all commands
 var TasksFile = path.Join(UserHome, ".dsync/tasks.dsync")
 
+//Task is a file or a directory to sync and the remotes to sync it to.
+type Task struct {
+	Path string `json:"path"`
+	//Dests are the remote names, no Dests stands for the default remote.
+	Dests []string `json:"dests,omitempty"`
+	//Mirror is the policy applied to remote files whose local source is
+	//gone, see MirrorTrash and MirrorDelete.
+	Mirror string `json:"mirror,omitempty"`
+	//TwoWay downloads the changes made to remote files before syncing.
+	TwoWay bool `json:"two_way,omitempty"`
+	//Conflict is the policy applied to files modified both locally and in
+	//the remote since their last sync, see ConflictKeepBoth.
+	Conflict string `json:"conflict,omitempty"`
+	//Exclude and Include are gitignore patterns of the files and folders
+	//that aren't synced and that are synced anyway, see Ignorer.
+	Exclude []string `json:"exclude,omitempty"`
+	Include []string `json:"include,omitempty"`
+	//Symlinks is the policy applied to symbolic links, see SymlinkFollow.
+	Symlinks string `json:"symlinks,omitempty"`
+	//Snapshot takes dated snapshots of the task, see Manifest.
+	Snapshot bool `json:"snapshot,omitempty"`
+	//Retention is the policy of the snapshots and file revisions kept by
+	//"dsync prune".
+	Retention *Retention `json:"retention,omitempty"`
+	//SharedDrive is the Id, or the name, of the shared drive the task is
+	//stored in by the remotes supporting shared drives, see SharedDriver.
+	SharedDrive     string `json:"shared_drive,omitempty"`
+	SharedDriveName string `json:"shared_drive_name,omitempty"`
+	//RemotePath is the path of the remote folder the task is synced under,
+	//e.g. "Backups/laptop", the remote root when it's empty.
+	RemotePath string `json:"remote_path,omitempty"`
+}
+
+//Remotes returns the names of the remotes the task is synced to.
+func (t Task) Remotes() []string {
+	if len(t.Dests) == 0 {
+		return []string{DefaultRemote}
+	}
+	return t.Dests
+}
+
+//FullAccess reports whether the task needs drive remotes authorized to use
+//the whole drive: shared drives and the remote changes pulled by two-way
+//syncs are out of reach of the files created by dsync.
+func (t Task) FullAccess() bool {
+	return t.SharedDrive != "" || t.TwoWay
+}
+
 //GetTasks returns a slice of all tasks to sync.
-func GetTasks() []string {
-	tasks, err := os.ReadFile(TasksFile)
+func GetTasks() []Task {
+	tasksData, err := os.ReadFile(TasksFile)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
 	if err != nil {
 		log.Fatalf("Unable to read file %q: %v", TasksFile, err)
 	}
-	return strings.Fields(string(tasks))
+	var tasks []Task
+	if !strings.HasPrefix(strings.TrimSpace(string(tasksData)), "[") {
+		//tasks list files written by older versions hold a task path by line
+		for _, taskPath := range strings.Split(string(tasksData), "\n") {
+			if strings.TrimSpace(taskPath) != "" {
+				tasks = append(tasks, Task{Path: taskPath})
+			}
+		}
+		return tasks
+	}
+	if err := json.Unmarshal(tasksData, &tasks); err != nil {
+		log.Fatalf("Unable to parse file %q: %v", TasksFile, err)
+	}
+	return tasks
+}
+
+//SaveTasks writes the given tasks to the tasks list file.
+func SaveTasks(tasks []Task) {
+	if err := os.Mkdir(path.Dir(TasksFile), 0750); err != nil && !os.IsExist(err) {
+		log.Fatalf("Could'n create '.dsync' folder: %v", err)
+	}
+	tasksData, err := json.MarshalIndent(tasks, "", "  ")
+	if err != nil {
+		log.Fatalf("Unable to encode tasks list: %v", err)
+	}
+	if err := os.WriteFile(TasksFile, tasksData, 0644); err != nil {
+		log.Fatalf("Unable to update tasks list file: %v", err)
+	}
+}
+
+//RunTask syncs the task file or directory to every task remote, backends
+//are reused from and added to the given map of backends by remote name.
+//A dry run only prints what would be synced, the remotes aren't called so
+//their backends aren't even created.
+func RunTask(task Task, backends map[string]Backend, dryRun bool) {
+	for _, remoteName := range task.Remotes() {
+		var backend Backend
+		if !dryRun {
+			backend = TaskBackend(task, remoteName, backends)
+		}
+		syncer := &Syncer{Remote: remoteName, Backend: backend, Mirror: task.Mirror, TwoWay: task.TwoWay, Conflict: task.Conflict, DryRun: dryRun}
+		syncer.Exclude, syncer.Include = task.Exclude, task.Include
+		syncer.Symlinks, syncer.Snapshot = task.Symlinks, task.Snapshot
+		syncer.RemotePath = task.RemotePath
+		syncer.Sync(task.Path)
+	}
 }
 
 // allCmd represents the all command
@@ -30,28 +128,19 @@ var allCmd = &cobra.Command{
 	Use:   "all",
 	Short: "Run all sync tasks",
 	Long: `Run all sync tasks added by the user:
-"dsync all"
+"dsync all [--dry-run]"
+Every task is synced to all its remotes.
+With "--dry-run" every file and folder that would be created, uploaded,
+updated or moved is printed with the byte totals of every task, planned
+from the sync state only: the remotes aren't called at all.
 You can list all sync tasks by using:
 "dsync list" command.`,
 	Args: cobra.ExactArgs(0),
 	Run: func(cmd *cobra.Command, args []string) {
-		tasksSlice := GetTasks()
-		for _, fileToSync := range tasksSlice {
-
-			fileStats, err := os.Lstat(fileToSync)
-			if err != nil {
-				log.Fatalf("Unable to get file or dir %q stats: %v", args[0], err)
-			}
-
-			srv := GetDriveService()
-
-			switch {
-			case fileStats.Mode().IsDir():
-				SyncDir(fileToSync, nil, srv)
-
-			case fileStats.Mode().IsRegular():
-				SyncFile(fileToSync, nil, srv)
-			}
+		dryRun, _ := cmd.Flags().GetBool("dry-run")
+		backends := make(map[string]Backend)
+		for _, task := range GetTasks() {
+			RunTask(task, backends, dryRun)
 		}
 	},
 }
@@ -67,5 +156,5 @@ func init() {
 
 	// Cobra supports local flags which will only run when this command
 	// is called directly, e.g.:
-	// allCmd.Flags().BoolP("toggle", "t", false, "Help message for toggle")
+	allCmd.Flags().BoolP("dry-run", "n", false, "Print what would be synced without syncing it")
 }
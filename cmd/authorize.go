@@ -7,7 +7,6 @@ package cmd
 import (
 	"log"
 	"os"
-	"path"
 
 	"github.com/spf13/cobra"
 )
@@ -17,13 +16,16 @@ var authorizeCmd = &cobra.Command{
 	Use:   "authorize",
 	Short: "Get authorization to use user Google Drive",
 	Long: `Get authorization to use user Google Drive:
-"dsync authorize".`,
+"dsync authorize [--full-access]".
+dsync is only authorized to use the files it created, tasks stored in a
+shared drive or synced both ways ask for access to the whole drive on their
+first sync, or ahead of it with "--full-access".`,
 	Run: func(cmd *cobra.Command, args []string) {
-		home := os.Getenv("HOME")
-		if err := os.Remove(path.Join(home, ".dsync/token.json")); err != nil {
+		fullAccess, _ := cmd.Flags().GetBool("full-access")
+		if err := os.Remove(driveTokenFile(fullAccess)); err != nil && !os.IsNotExist(err) {
 			log.Fatal(err)
 		}
-		GetDriveService()
+		GetDriveService(GetRemote(DefaultRemote), fullAccess)
 	},
 }
 
@@ -39,4 +41,5 @@ func init() {
 	// Cobra supports local flags which will only run when this command
 	// is called directly, e.g.:
 	// authorizeCmd.Flags().BoolP("toggle", "t", false, "Help message for toggle")
+	authorizeCmd.Flags().Bool("full-access", false, "Authorize dsync to use the whole drive, needed by shared drives and two-way syncs")
 }
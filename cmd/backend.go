@@ -0,0 +1,103 @@
+/*
+Copyright © 2022 NAME HERE <EMAIL ADDRESS>
+
+*/
+package cmd
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"time"
+)
+
+//RemoteFile describes a file or a folder stored in a Backend.
+type RemoteFile struct {
+	ID      string
+	Name    string
+	Parent  string
+	IsDir   bool
+	Size    int64
+	ModTime time.Time
+	MD5     string
+	//Revision identifies the current content of a file, it changes every
+	//time the file is updated (Drive head revision, S3 or WebDAV ETag).
+	Revision string
+	//Trashed is set for files and folders in the backend trash bin.
+	Trashed bool
+	//Created is the time the remote file was created, it's zero when the
+	//backend doesn't tell.
+	Created time.Time
+	//LinkTarget is the path a symbolic link stored by a Linker points to.
+	LinkTarget string
+	//Mode, UID and GID are the permissions and the owner of the local file
+	//a remote file was synced from, they are sent on upload and Mode is 0
+	//when the backend doesn't keep them. ModTime is the local modification
+	//time for those backends.
+	Mode     os.FileMode
+	UID, GID int
+}
+
+//Backend is a storage destination the sync engine can backup files to.
+//An empty parent Id stands for the backend root folder.
+type Backend interface {
+	//CreateFolder creates the folder f.Name inside f.Parent.
+	CreateFolder(f *RemoteFile) (*RemoteFile, error)
+	//Upload creates the file f.Name inside f.Parent with the content of r,
+	//backends able to keep them also store f.ModTime, f.Mode and the owner.
+	Upload(f *RemoteFile, r io.Reader) (*RemoteFile, error)
+	//Update replaces the content of the file f.ID with the content of r,
+	//and the metadata like Upload.
+	Update(f *RemoteFile, r io.Reader) (*RemoteFile, error)
+	//Stat returns the file or folder with the given Id.
+	Stat(id string) (*RemoteFile, error)
+	//List returns all files and folders inside the given parent.
+	List(parent string) ([]*RemoteFile, error)
+	//Delete removes the file or folder with the given Id.
+	Delete(id string) error
+	//Download writes the content of the file with the given Id to w.
+	Download(id string, w io.Writer) error
+}
+
+//HTTPIdleTimeout is how long the HTTP and SFTP backends wait on a stalled
+//server before failing the request.
+var HTTPIdleTimeout = time.Minute
+
+//newHTTPClient returns the client of the HTTP backends. Requests fail once
+//the connection is idle for HTTPIdleTimeout, long transfers don't.
+func newHTTPClient() *http.Client {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	dialer := &net.Dialer{Timeout: HTTPIdleTimeout, KeepAlive: 30 * time.Second}
+	transport.DialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+		conn, err := dialer.DialContext(ctx, network, addr)
+		if err != nil {
+			return nil, err
+		}
+		return &idleTimeoutConn{Conn: conn}, nil
+	}
+	transport.ResponseHeaderTimeout = HTTPIdleTimeout
+	return &http.Client{Transport: transport}
+}
+
+//idleTimeoutConn is a connection whose reads and writes fail once it's
+//idle for HTTPIdleTimeout. Writes also push back the deadline of the read
+//waiting for the response while a request body is sent.
+type idleTimeoutConn struct {
+	net.Conn
+}
+
+func (c *idleTimeoutConn) Read(b []byte) (int, error) {
+	if err := c.Conn.SetReadDeadline(time.Now().Add(HTTPIdleTimeout)); err != nil {
+		return 0, err
+	}
+	return c.Conn.Read(b)
+}
+
+func (c *idleTimeoutConn) Write(b []byte) (int, error) {
+	if err := c.Conn.SetDeadline(time.Now().Add(HTTPIdleTimeout)); err != nil {
+		return 0, err
+	}
+	return c.Conn.Write(b)
+}
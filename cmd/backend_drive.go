@@ -0,0 +1,404 @@
+/*
+Copyright © 2022 NAME HERE <EMAIL ADDRESS>
+
+*/
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"google.golang.org/api/drive/v3"
+	"google.golang.org/api/googleapi"
+)
+
+const (
+	driveFolderMimeType = "application/vnd.google-apps.folder"
+	driveFileFields     = "id, name, parents, mimeType, size, createdTime, modifiedTime, md5Checksum, headRevisionId, trashed, appProperties"
+	//driveLinkTarget is the app property holding the target of the files
+	//storing symbolic links
+	driveLinkTarget = "dsyncLinkTarget"
+	//app properties holding the metadata of the synced local files
+	driveMode  = "dsyncMode"
+	driveUID   = "dsyncUid"
+	driveGID   = "dsyncGid"
+	driveMTime = "dsyncMtime"
+)
+
+//driveBackend stores files in Google Drive.
+type driveBackend struct {
+	srv *drive.Service
+	//driveId is the shared drive the files are stored in, the user own
+	//drive when it's empty
+	driveId string
+	//fullAccess is set when srv is authorized to use the whole drive
+	fullAccess bool
+}
+
+//NewDriveBackend returns a Backend that stores files in Google Drive, srv
+//is authorized to use the whole drive when fullAccess is set.
+func NewDriveBackend(srv *drive.Service, fullAccess bool) Backend {
+	return &driveBackend{srv: srv, fullAccess: fullAccess}
+}
+
+//errNoFullAccess is returned by the calls that need access to the whole
+//drive, they fail or miss the files of other apps otherwise.
+var errNoFullAccess = errors.New("dsync isn't authorized to use the whole drive")
+
+func (d *driveBackend) CreateFolder(f *RemoteFile) (*RemoteFile, error) {
+	folderMeta := &drive.File{
+		Name:     f.Name,
+		MimeType: driveFolderMimeType,
+		Parents:  d.parents(f.Parent),
+	}
+	driveFolder, err := d.srv.Files.Create(folderMeta).SupportsAllDrives(true).Fields(driveFileFields).Do()
+	if err != nil {
+		return nil, err
+	}
+	return fromDriveFile(driveFolder), nil
+}
+
+func (d *driveBackend) Upload(f *RemoteFile, r io.Reader) (*RemoteFile, error) {
+	fileMeta := driveMeta(f)
+	fileMeta.Name = f.Name
+	fileMeta.Parents = d.parents(f.Parent)
+	driveFile, err := d.srv.Files.Create(fileMeta).SupportsAllDrives(true).Media(r).Fields(driveFileFields).Do()
+	if err != nil {
+		return nil, err
+	}
+	return fromDriveFile(driveFile), nil
+}
+
+func (d *driveBackend) Update(f *RemoteFile, r io.Reader) (*RemoteFile, error) {
+	fileMeta := driveMeta(f)
+	//the file may have stored a symbolic link
+	fileMeta.AppProperties[driveLinkTarget] = ""
+	driveFile, err := d.srv.Files.Update(f.ID, fileMeta).SupportsAllDrives(true).Media(r).Fields(driveFileFields).Do()
+	if err != nil {
+		return nil, driveError(err)
+	}
+	return fromDriveFile(driveFile), nil
+}
+
+func (d *driveBackend) Stat(id string) (*RemoteFile, error) {
+	driveFile, err := d.srv.Files.Get(id).SupportsAllDrives(true).Fields(driveFileFields).Do()
+	if err != nil {
+		return nil, driveError(err)
+	}
+	return fromDriveFile(driveFile), nil
+}
+
+func (d *driveBackend) List(parent string) ([]*RemoteFile, error) {
+	if parent == "" {
+		parent = d.root()
+	}
+	call := d.srv.Files.List().SupportsAllDrives(true)
+	if d.driveId != "" {
+		call = call.Corpora("drive").DriveId(d.driveId).IncludeItemsFromAllDrives(true)
+	}
+	var files []*RemoteFile
+	err := call.
+		Q(fmt.Sprintf("'%s' in parents and trashed = false", parent)).
+		Fields("nextPageToken, files("+driveFileFields+")").
+		Pages(context.Background(), func(page *drive.FileList) error {
+			for _, driveFile := range page.Files {
+				files = append(files, fromDriveFile(driveFile))
+			}
+			return nil
+		})
+	if err != nil {
+		return nil, err
+	}
+	return files, nil
+}
+
+func (d *driveBackend) Delete(id string) error {
+	return d.srv.Files.Delete(id).SupportsAllDrives(true).Do()
+}
+
+func (d *driveBackend) Move(f *RemoteFile) (*RemoteFile, error) {
+	oldFile, err := d.srv.Files.Get(f.ID).SupportsAllDrives(true).Fields("parents").Do()
+	if err != nil {
+		return nil, err
+	}
+	newParent := f.Parent
+	if newParent == "" && d.driveId != "" {
+		newParent = d.driveId
+	}
+	if newParent == "" {
+		//parents hold the real Id of the root folder, not its alias
+		root, err := d.srv.Files.Get("root").Fields("id").Do()
+		if err != nil {
+			return nil, err
+		}
+		newParent = root.Id
+	}
+	call := d.srv.Files.Update(f.ID, &drive.File{Name: f.Name}).SupportsAllDrives(true).Fields(driveFileFields)
+	if len(oldFile.Parents) != 1 || oldFile.Parents[0] != newParent {
+		call = call.AddParents(newParent).RemoveParents(strings.Join(oldFile.Parents, ","))
+	}
+	driveFile, err := call.Do()
+	if err != nil {
+		return nil, err
+	}
+	return fromDriveFile(driveFile), nil
+}
+
+func (d *driveBackend) Trash(id string) error {
+	_, err := d.srv.Files.Update(id, &drive.File{Trashed: true}).SupportsAllDrives(true).Do()
+	return err
+}
+
+func (d *driveBackend) Untrash(id string) error {
+	untrashed := &drive.File{Trashed: false, ForceSendFields: []string{"Trashed"}}
+	_, err := d.srv.Files.Update(id, untrashed).SupportsAllDrives(true).Do()
+	return driveError(err)
+}
+
+//Symlink stores a symbolic link as a file holding its target, the target
+//is also kept in an app property.
+func (d *driveBackend) Symlink(f *RemoteFile, target string) (*RemoteFile, error) {
+	fileMeta := &drive.File{AppProperties: map[string]string{driveLinkTarget: target}}
+	var driveFile *drive.File
+	var err error
+	if f.ID != "" {
+		driveFile, err = d.srv.Files.Update(f.ID, fileMeta).SupportsAllDrives(true).Media(strings.NewReader(target)).Fields(driveFileFields).Do()
+	} else {
+		fileMeta.Name = f.Name
+		fileMeta.Parents = d.parents(f.Parent)
+		driveFile, err = d.srv.Files.Create(fileMeta).SupportsAllDrives(true).Media(strings.NewReader(target)).Fields(driveFileFields).Do()
+	}
+	if err != nil {
+		return nil, err
+	}
+	return fromDriveFile(driveFile), nil
+}
+
+func (d *driveBackend) Download(id string, w io.Writer) error {
+	res, err := d.srv.Files.Get(id).SupportsAllDrives(true).Download()
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	_, err = io.Copy(w, res.Body)
+	return err
+}
+
+func (d *driveBackend) Revisions(id string) ([]*Revision, error) {
+	var revisions []*Revision
+	err := d.srv.Revisions.List(id).
+		Fields("nextPageToken, revisions(id, modifiedTime, size, md5Checksum, keepForever)").
+		Pages(context.Background(), func(page *drive.RevisionList) error {
+			for _, driveRevision := range page.Revisions {
+				revision := &Revision{
+					ID:          driveRevision.Id,
+					Size:        driveRevision.Size,
+					MD5:         driveRevision.Md5Checksum,
+					KeepForever: driveRevision.KeepForever,
+				}
+				if modTime, err := time.Parse(time.RFC3339, driveRevision.ModifiedTime); err == nil {
+					revision.ModTime = modTime
+				}
+				revisions = append(revisions, revision)
+			}
+			return nil
+		})
+	if err != nil {
+		return nil, err
+	}
+	return revisions, nil
+}
+
+func (d *driveBackend) DownloadRevision(id, revision string, w io.Writer) error {
+	res, err := d.srv.Revisions.Get(id, revision).Download()
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	_, err = io.Copy(w, res.Body)
+	return err
+}
+
+func (d *driveBackend) KeepRevision(id, revision string, keep bool) error {
+	_, err := d.srv.Revisions.Update(id, revision, &drive.Revision{
+		KeepForever: keep,
+		//false is omitted from the request otherwise
+		ForceSendFields: []string{"KeepForever"},
+	}).Do()
+	return err
+}
+
+func (d *driveBackend) DeleteRevision(id, revision string) error {
+	return d.srv.Revisions.Delete(id, revision).Do()
+}
+
+func (d *driveBackend) StartPageToken() (string, error) {
+	if !d.fullAccess {
+		return "", errNoFullAccess
+	}
+	call := d.srv.Changes.GetStartPageToken().SupportsAllDrives(true)
+	if d.driveId != "" {
+		call = call.DriveId(d.driveId)
+	}
+	startToken, err := call.Do()
+	if err != nil {
+		return "", err
+	}
+	return startToken.StartPageToken, nil
+}
+
+func (d *driveBackend) Changes(token string) ([]*Change, string, error) {
+	if !d.fullAccess {
+		return nil, "", errNoFullAccess
+	}
+	var changes []*Change
+	for {
+		call := d.srv.Changes.List(token).SupportsAllDrives(true).IncludeItemsFromAllDrives(true)
+		if d.driveId != "" {
+			call = call.DriveId(d.driveId)
+		}
+		page, err := call.
+			Fields("nextPageToken, newStartPageToken, changes(fileId, removed, file(" + driveFileFields + "))").
+			Do()
+		if err != nil {
+			return nil, "", err
+		}
+		for _, driveChange := range page.Changes {
+			change := &Change{ID: driveChange.FileId, Removed: driveChange.Removed}
+			if driveChange.File != nil {
+				change.File = fromDriveFile(driveChange.File)
+			}
+			changes = append(changes, change)
+		}
+		if page.NextPageToken == "" {
+			return changes, page.NewStartPageToken, nil
+		}
+		token = page.NextPageToken
+	}
+}
+
+//driveMeta returns a Drive file holding the metadata of the local file f
+//was synced from.
+func driveMeta(f *RemoteFile) *drive.File {
+	driveFile := &drive.File{AppProperties: make(map[string]string)}
+	if !f.ModTime.IsZero() {
+		driveFile.ModifiedTime = f.ModTime.UTC().Format(time.RFC3339Nano)
+		driveFile.AppProperties[driveMTime] = f.ModTime.UTC().Format(time.RFC3339Nano)
+	}
+	if f.Mode != 0 {
+		driveFile.AppProperties[driveMode] = strconv.FormatUint(uint64(f.Mode), 8)
+		driveFile.AppProperties[driveUID] = strconv.Itoa(f.UID)
+		driveFile.AppProperties[driveGID] = strconv.Itoa(f.GID)
+	}
+	return driveFile
+}
+
+func (d *driveBackend) SharedDrive(idOrName string) (Backend, *RemoteFile, error) {
+	if !d.fullAccess {
+		return nil, nil, errNoFullAccess
+	}
+	sharedDrive, err := d.srv.Drives.Get(idOrName).Fields("id, name").Do()
+	if err == nil {
+		return &driveBackend{srv: d.srv, driveId: sharedDrive.Id, fullAccess: true}, fromDriveShared(sharedDrive), nil
+	}
+	var found []*drive.Drive
+	listErr := d.srv.Drives.List().Fields("nextPageToken, drives(id, name)").
+		Pages(context.Background(), func(page *drive.DriveList) error {
+			for _, sharedDrive := range page.Drives {
+				if sharedDrive.Name == idOrName {
+					found = append(found, sharedDrive)
+				}
+			}
+			return nil
+		})
+	switch {
+	case listErr != nil:
+		return nil, nil, listErr
+	case len(found) == 0:
+		return nil, nil, fmt.Errorf("no shared drive with Id or name %q: %w", idOrName, err)
+	case len(found) > 1:
+		return nil, nil, fmt.Errorf("%v shared drives are named %q, use the shared drive Id", len(found), idOrName)
+	}
+	return &driveBackend{srv: d.srv, driveId: found[0].Id, fullAccess: true}, fromDriveShared(found[0]), nil
+}
+
+//fromDriveShared returns the root folder of a shared drive.
+func fromDriveShared(sharedDrive *drive.Drive) *RemoteFile {
+	return &RemoteFile{ID: sharedDrive.Id, Name: sharedDrive.Name, IsDir: true}
+}
+
+//root returns the Id of the root folder of the backend drive.
+func (d *driveBackend) root() string {
+	if d.driveId != "" {
+		//the Id of a shared drive is the Id of its root folder
+		return d.driveId
+	}
+	return "root"
+}
+
+//parents returns the Drive parents list for the given parent Id.
+func (d *driveBackend) parents(parent string) []string {
+	if parent == "" && d.driveId != "" {
+		return []string{d.driveId}
+	}
+	if parent == "" {
+		return nil
+	}
+	return []string{parent}
+}
+
+//fromDriveFile converts a Drive file to a RemoteFile.
+func fromDriveFile(driveFile *drive.File) *RemoteFile {
+	f := &RemoteFile{
+		ID:       driveFile.Id,
+		Name:     driveFile.Name,
+		IsDir:    driveFile.MimeType == driveFolderMimeType,
+		Size:     driveFile.Size,
+		MD5:      driveFile.Md5Checksum,
+		Revision: driveFile.HeadRevisionId,
+		Trashed:  driveFile.Trashed,
+		//files storing symbolic links
+		LinkTarget: driveFile.AppProperties[driveLinkTarget],
+	}
+	if len(driveFile.Parents) > 0 {
+		f.Parent = driveFile.Parents[0]
+	}
+	if modTime, err := time.Parse(time.RFC3339, driveFile.ModifiedTime); err == nil {
+		f.ModTime = modTime
+	}
+	if created, err := time.Parse(time.RFC3339, driveFile.CreatedTime); err == nil {
+		f.Created = created
+	}
+	//metadata of the synced local file
+	if modTime, err := time.Parse(time.RFC3339Nano, driveFile.AppProperties[driveMTime]); err == nil {
+		f.ModTime = modTime
+	}
+	if mode, err := strconv.ParseUint(driveFile.AppProperties[driveMode], 8, 32); err == nil {
+		f.Mode = os.FileMode(mode)
+		f.UID, f.GID = -1, -1
+		if uid, err := strconv.Atoi(driveFile.AppProperties[driveUID]); err == nil {
+			f.UID = uid
+		}
+		if gid, err := strconv.Atoi(driveFile.AppProperties[driveGID]); err == nil {
+			f.GID = gid
+		}
+	}
+	return f
+}
+
+//driveError wraps the not found errors of the Drive API in os.ErrNotExist,
+//files deleted from Drive are recreated by the next sync.
+func driveError(err error) error {
+	var apiErr *googleapi.Error
+	if errors.As(err, &apiErr) && apiErr.Code == http.StatusNotFound {
+		return fmt.Errorf("%w: %v", os.ErrNotExist, err)
+	}
+	return err
+}
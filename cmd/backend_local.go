@@ -0,0 +1,203 @@
+/*
+Copyright © 2022 NAME HERE <EMAIL ADDRESS>
+
+*/
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"time"
+)
+
+//localTrash is the folder of the backend root trashed files are moved to.
+const localTrash = ".dsync-trash"
+
+//localBackend stores files in a local or mounted (NAS) directory.
+//Ids are slash separated paths relative to the backend root directory.
+type localBackend struct {
+	root string
+}
+
+//NewLocalBackend returns a Backend that stores files inside the root directory.
+func NewLocalBackend(root string) Backend {
+	return &localBackend{root: root}
+}
+
+func (l *localBackend) CreateFolder(f *RemoteFile) (*RemoteFile, error) {
+	id := path.Join(f.Parent, f.Name)
+	if err := os.MkdirAll(l.fullPath(id), 0750); err != nil {
+		return nil, err
+	}
+	return l.Stat(id)
+}
+
+func (l *localBackend) Upload(f *RemoteFile, r io.Reader) (*RemoteFile, error) {
+	id := path.Join(f.Parent, f.Name)
+	if err := l.write(id, f, r); err != nil {
+		return nil, err
+	}
+	return l.Stat(id)
+}
+
+func (l *localBackend) Update(f *RemoteFile, r io.Reader) (*RemoteFile, error) {
+	if _, err := os.Stat(l.fullPath(f.ID)); err != nil {
+		return nil, err
+	}
+	if err := l.write(f.ID, f, r); err != nil {
+		return nil, err
+	}
+	return l.Stat(f.ID)
+}
+
+func (l *localBackend) Stat(id string) (*RemoteFile, error) {
+	fileStats, err := os.Lstat(l.fullPath(id))
+	if err != nil {
+		return nil, err
+	}
+	return l.remoteFile(id, fileStats)
+}
+
+func (l *localBackend) List(parent string) ([]*RemoteFile, error) {
+	entries, err := os.ReadDir(l.fullPath(parent))
+	if err != nil {
+		return nil, err
+	}
+	var files []*RemoteFile
+	for _, entry := range entries {
+		fileStats, err := entry.Info()
+		if err != nil {
+			return nil, err
+		}
+		f, err := l.remoteFile(path.Join(parent, entry.Name()), fileStats)
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, f)
+	}
+	return files, nil
+}
+
+func (l *localBackend) Delete(id string) error {
+	if _, err := os.Lstat(l.fullPath(id)); err != nil {
+		return err
+	}
+	return os.RemoveAll(l.fullPath(id))
+}
+
+func (l *localBackend) Move(f *RemoteFile) (*RemoteFile, error) {
+	id := path.Join(f.Parent, f.Name)
+	if err := os.MkdirAll(filepath.Dir(l.fullPath(id)), 0750); err != nil {
+		return nil, err
+	}
+	if err := os.Rename(l.fullPath(f.ID), l.fullPath(id)); err != nil {
+		return nil, err
+	}
+	return l.Stat(id)
+}
+
+//Trash moves the given Id to the ".dsync-trash" folder of the backend root.
+func (l *localBackend) Trash(id string) error {
+	trashPath := filepath.Join(l.root, localTrash, time.Now().Format("20060102T150405"), filepath.FromSlash(id))
+	if err := os.MkdirAll(filepath.Dir(trashPath), 0750); err != nil {
+		return err
+	}
+	return os.Rename(l.fullPath(id), trashPath)
+}
+
+//Symlink stores a symbolic link as a symbolic link.
+func (l *localBackend) Symlink(f *RemoteFile, target string) (*RemoteFile, error) {
+	id := f.ID
+	if id == "" {
+		id = path.Join(f.Parent, f.Name)
+	}
+	if err := createLink(l.fullPath(id), target); err != nil {
+		return nil, err
+	}
+	return l.Stat(id)
+}
+
+func (l *localBackend) Download(id string, w io.Writer) error {
+	f, err := os.Open(l.fullPath(id))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = io.Copy(w, f)
+	return err
+}
+
+//fullPath returns the local path of the given Id.
+func (l *localBackend) fullPath(id string) string {
+	return filepath.Join(l.root, filepath.FromSlash(id))
+}
+
+//write copies r to a temporary file and then renames it to the given Id,
+//so a failed copy never leaves a half written backup behind. The backup
+//keeps the modification time and the mode of f.
+func (l *localBackend) write(id string, f *RemoteFile, r io.Reader) error {
+	target := l.fullPath(id)
+	tmp, err := os.CreateTemp(filepath.Dir(target), ".dsync-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := io.Copy(tmp, r); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	mode := f.Mode
+	if mode == 0 {
+		mode = 0644
+	}
+	if err := os.Chmod(tmp.Name(), mode); err != nil {
+		return err
+	}
+	if !f.ModTime.IsZero() {
+		if err := os.Chtimes(tmp.Name(), f.ModTime, f.ModTime); err != nil {
+			return err
+		}
+	}
+	return os.Rename(tmp.Name(), target)
+}
+
+//remoteFile converts the stats of the file with the given Id to a
+//RemoteFile, reading the target of symbolic links.
+func (l *localBackend) remoteFile(id string, fileStats os.FileInfo) (*RemoteFile, error) {
+	f := localRemoteFile(id, fileStats)
+	if fileStats.Mode()&os.ModeSymlink != 0 {
+		target, err := os.Readlink(l.fullPath(id))
+		if err != nil {
+			return nil, err
+		}
+		f.LinkTarget = target
+	}
+	return f, nil
+}
+
+//localRemoteFile converts local file stats to a RemoteFile.
+func localRemoteFile(id string, fileStats os.FileInfo) *RemoteFile {
+	parent := path.Dir(id)
+	if parent == "." {
+		parent = ""
+	}
+	return &RemoteFile{
+		ID:      id,
+		Name:    fileStats.Name(),
+		Parent:  parent,
+		IsDir:   fileStats.IsDir(),
+		Size:    fileStats.Size(),
+		ModTime: fileStats.ModTime(),
+		Mode:    fileStats.Mode().Perm() | fileStats.Mode()&(os.ModeSetuid|os.ModeSetgid|os.ModeSticky),
+		UID:     -1,
+		GID:     -1,
+		//a local file changes when its modification time or size changes
+		Revision: fmt.Sprintf("%x-%x", fileStats.ModTime().UnixNano(), fileStats.Size()),
+	}
+}
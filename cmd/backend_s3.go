@@ -0,0 +1,386 @@
+/*
+Copyright © 2022 NAME HERE <EMAIL ADDRESS>
+
+*/
+package cmd
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"time"
+)
+
+//s3Backend stores files in a S3 compatible bucket (AWS, MinIO...), folders
+//are mapped to key prefixes. File Ids are object keys and folder Ids are key
+//prefixes ending with a slash.
+type s3Backend struct {
+	endpoint  *url.URL
+	region    string
+	bucket    string
+	prefix    string
+	accessKey string
+	secretKey string
+	client    *http.Client
+}
+
+//NewS3Backend returns a Backend that stores files in the given bucket under
+//the given key prefix, requests use path style addressing.
+func NewS3Backend(endpoint, region, bucket, prefix, accessKey, secretKey string) (Backend, error) {
+	u, err := url.Parse(strings.TrimSuffix(endpoint, "/"))
+	if err != nil {
+		return nil, err
+	}
+	if region == "" {
+		region = "us-east-1"
+	}
+	prefix = strings.Trim(prefix, "/")
+	if prefix != "" {
+		prefix += "/"
+	}
+	return &s3Backend{
+		endpoint:  u,
+		region:    region,
+		bucket:    bucket,
+		prefix:    prefix,
+		accessKey: accessKey,
+		secretKey: secretKey,
+		client:    newHTTPClient(),
+	}, nil
+}
+
+func (s *s3Backend) CreateFolder(f *RemoteFile) (*RemoteFile, error) {
+	//prefixes don't need to be created
+	return s.Stat(s.key(f.Parent, f.Name) + "/")
+}
+
+func (s *s3Backend) Upload(f *RemoteFile, r io.Reader) (*RemoteFile, error) {
+	return s.put(s.key(f.Parent, f.Name), r)
+}
+
+func (s *s3Backend) Update(f *RemoteFile, r io.Reader) (*RemoteFile, error) {
+	if _, err := s.Stat(f.ID); err != nil {
+		return nil, err
+	}
+	return s.put(f.ID, r)
+}
+
+func (s *s3Backend) Stat(id string) (*RemoteFile, error) {
+	if strings.HasSuffix(id, "/") {
+		return &RemoteFile{
+			ID:     id,
+			Name:   path.Base(id),
+			Parent: s.parent(id),
+			IsDir:  true,
+		}, nil
+	}
+	res, err := s.do(http.MethodHead, id, nil, nil, -1)
+	if err != nil {
+		return nil, err
+	}
+	res.Body.Close()
+	if res.StatusCode == http.StatusNotFound {
+		return nil, fmt.Errorf("HEAD %q: %w", id, os.ErrNotExist)
+	}
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("HEAD %q: %v", id, res.Status)
+	}
+	f := &RemoteFile{
+		ID:     id,
+		Name:   path.Base(id),
+		Parent: s.parent(id),
+		Size:   res.ContentLength,
+	}
+	if modTime, err := time.Parse(http.TimeFormat, res.Header.Get("Last-Modified")); err == nil {
+		f.ModTime = modTime
+	}
+	s.setETag(f, res.Header.Get("ETag"))
+	return f, nil
+}
+
+func (s *s3Backend) List(parent string) ([]*RemoteFile, error) {
+	return s.list(s.folderKey(parent), "/")
+}
+
+func (s *s3Backend) Delete(id string) error {
+	ids := []string{id}
+	if strings.HasSuffix(id, "/") {
+		//deleting a folder deletes every object under its prefix
+		files, err := s.list(id, "")
+		if err != nil {
+			return err
+		}
+		ids = nil
+		for _, f := range files {
+			ids = append(ids, f.ID)
+		}
+	}
+	for _, id := range ids {
+		res, err := s.do(http.MethodDelete, id, nil, nil, -1)
+		if err != nil {
+			return err
+		}
+		res.Body.Close()
+		if res.StatusCode/100 != 2 {
+			return fmt.Errorf("DELETE %q: %v", id, res.Status)
+		}
+	}
+	return nil
+}
+
+func (s *s3Backend) Download(id string, w io.Writer) error {
+	res, err := s.do(http.MethodGet, id, nil, nil, -1)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("GET %q: %v", id, res.Status)
+	}
+	_, err = io.Copy(w, res.Body)
+	return err
+}
+
+//put uploads the content of r to the given key.
+func (s *s3Backend) put(key string, r io.Reader) (*RemoteFile, error) {
+	//S3 needs the content length in advance
+	size := int64(-1)
+	if file, ok := r.(*os.File); ok {
+		if fileStats, err := file.Stat(); err == nil {
+			size = fileStats.Size()
+		}
+	}
+	if size < 0 {
+		data, err := io.ReadAll(r)
+		if err != nil {
+			return nil, err
+		}
+		r = bytes.NewReader(data)
+		size = int64(len(data))
+	}
+	res, err := s.do(http.MethodPut, key, nil, r, size)
+	if err != nil {
+		return nil, err
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("PUT %q: %v", key, res.Status)
+	}
+	f := &RemoteFile{
+		ID:      key,
+		Name:    path.Base(key),
+		Parent:  s.parent(key),
+		Size:    size,
+		ModTime: time.Now(),
+	}
+	s.setETag(f, res.Header.Get("ETag"))
+	return f, nil
+}
+
+//list returns the objects and, when a delimiter is given, the folders
+//under the given prefix.
+func (s *s3Backend) list(prefix, delimiter string) ([]*RemoteFile, error) {
+	var files []*RemoteFile
+	query := url.Values{}
+	query.Set("list-type", "2")
+	query.Set("prefix", prefix)
+	if delimiter != "" {
+		query.Set("delimiter", delimiter)
+	}
+	for {
+		res, err := s.do(http.MethodGet, "", query, nil, -1)
+		if err != nil {
+			return nil, err
+		}
+		var result s3ListResult
+		err = xml.NewDecoder(res.Body).Decode(&result)
+		res.Body.Close()
+		if res.StatusCode != http.StatusOK {
+			return nil, fmt.Errorf("LIST %q: %v", prefix, res.Status)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("LIST %q: %v", prefix, err)
+		}
+		for _, commonPrefix := range result.CommonPrefixes {
+			files = append(files, &RemoteFile{
+				ID:     commonPrefix.Prefix,
+				Name:   path.Base(commonPrefix.Prefix),
+				Parent: s.parent(commonPrefix.Prefix),
+				IsDir:  true,
+			})
+		}
+		for _, object := range result.Contents {
+			if object.Key == prefix {
+				continue
+			}
+			f := &RemoteFile{
+				ID:      object.Key,
+				Name:    path.Base(object.Key),
+				Parent:  s.parent(object.Key),
+				IsDir:   strings.HasSuffix(object.Key, "/"),
+				Size:    object.Size,
+				ModTime: object.LastModified,
+			}
+			s.setETag(f, object.ETag)
+			files = append(files, f)
+		}
+		if !result.IsTruncated {
+			return files, nil
+		}
+		query.Set("continuation-token", result.NextContinuationToken)
+	}
+}
+
+//key returns the object key of the given name inside the parent folder.
+func (s *s3Backend) key(parent, name string) string {
+	return s.folderKey(parent) + name
+}
+
+//folderKey returns the key prefix of the given folder Id.
+func (s *s3Backend) folderKey(id string) string {
+	if id == "" {
+		return s.prefix
+	}
+	return id
+}
+
+//parent returns the folder Id of the given key.
+func (s *s3Backend) parent(key string) string {
+	parent := path.Dir(strings.TrimSuffix(key, "/")) + "/"
+	if parent == "./" || parent == s.prefix {
+		return ""
+	}
+	return parent
+}
+
+//setETag stores the object ETag as the file revision, ETags of objects
+//uploaded in a single part are the MD5 of its content.
+func (s *s3Backend) setETag(f *RemoteFile, etag string) {
+	f.Revision = strings.Trim(etag, `"`)
+	if len(f.Revision) == 32 {
+		f.MD5 = f.Revision
+	}
+}
+
+//do sends a signed request for the given key to the S3 endpoint.
+func (s *s3Backend) do(method, key string, query url.Values, body io.Reader, size int64) (*http.Response, error) {
+	u := *s.endpoint
+	u.Path = strings.TrimSuffix(u.Path, "/") + "/" + s.bucket
+	if key != "" {
+		u.Path += "/" + key
+	}
+	u.RawPath = s3EscapePath(u.Path)
+	u.RawQuery = s3Escape(query)
+	req, err := http.NewRequest(method, u.String(), body)
+	if err != nil {
+		return nil, err
+	}
+	if size >= 0 {
+		req.ContentLength = size
+	}
+	s.sign(req, time.Now().UTC())
+	return s.client.Do(req)
+}
+
+//sign adds the AWS signature version 4 headers to the request.
+func (s *s3Backend) sign(req *http.Request, now time.Time) {
+	amzDate := now.Format("20060102T150405Z")
+	scope := fmt.Sprintf("%s/%s/s3/aws4_request", now.Format("20060102"), s.region)
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", "UNSIGNED-PAYLOAD")
+	signedHeaders := "host;x-amz-content-sha256;x-amz-date"
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		req.URL.RawQuery,
+		"host:" + req.URL.Host,
+		"x-amz-content-sha256:UNSIGNED-PAYLOAD",
+		"x-amz-date:" + amzDate,
+		"",
+		signedHeaders,
+		"UNSIGNED-PAYLOAD",
+	}, "\n")
+	hash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := strings.Join([]string{
+		"AWS4-HMAC-SHA256",
+		amzDate,
+		scope,
+		hex.EncodeToString(hash[:]),
+	}, "\n")
+	signingKey := hmacSHA256([]byte("AWS4"+s.secretKey), now.Format("20060102"))
+	signingKey = hmacSHA256(signingKey, s.region)
+	signingKey = hmacSHA256(signingKey, "s3")
+	signingKey = hmacSHA256(signingKey, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(signingKey, stringToSign))
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		s.accessKey, scope, signedHeaders, signature))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+//s3Escape encodes the query the way AWS signature expects, sorted by key
+//and with spaces encoded as %20.
+func s3Escape(query url.Values) string {
+	var keys []string
+	for key := range query {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	var pairs []string
+	for _, key := range keys {
+		for _, value := range query[key] {
+			pairs = append(pairs, s3EscapeString(key)+"="+s3EscapeString(value))
+		}
+	}
+	return strings.Join(pairs, "&")
+}
+
+//s3EscapePath encodes every path segment the way AWS signature expects.
+func s3EscapePath(p string) string {
+	segments := strings.Split(p, "/")
+	for i, segment := range segments {
+		segments[i] = s3EscapeString(segment)
+	}
+	return strings.Join(segments, "/")
+}
+
+func s3EscapeString(s string) string {
+	var b strings.Builder
+	for _, c := range []byte(s) {
+		if 'A' <= c && c <= 'Z' || 'a' <= c && c <= 'z' || '0' <= c && c <= '9' || strings.IndexByte("-_.~", c) >= 0 {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+//s3ListResult is the body of a ListObjectsV2 response.
+type s3ListResult struct {
+	IsTruncated           bool
+	NextContinuationToken string
+	Contents              []struct {
+		Key          string
+		LastModified time.Time
+		ETag         string
+		Size         int64
+	}
+	CommonPrefixes []struct {
+		Prefix string
+	}
+}
@@ -0,0 +1,314 @@
+/*
+Copyright © 2022 NAME HERE <EMAIL ADDRESS>
+
+*/
+package cmd
+
+import (
+	"bytes"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+//fakeS3 is an in-memory S3 bucket checking the signature version 4 of every
+//request.
+type fakeS3 struct {
+	bucket            string
+	region            string
+	accessKey         string
+	secretKey         string
+	mu                sync.Mutex
+	objects           map[string][]byte
+	modTimes          map[string]time.Time
+	badSignatures     int
+	continuationPages int
+}
+
+func startFakeS3(t *testing.T, bucket, region, accessKey, secretKey string) (*fakeS3, *httptest.Server) {
+	t.Helper()
+	s := &fakeS3{
+		bucket: bucket, region: region, accessKey: accessKey, secretKey: secretKey,
+		objects: make(map[string][]byte), modTimes: make(map[string]time.Time),
+	}
+	server := httptest.NewServer(s)
+	t.Cleanup(server.Close)
+	return s, server
+}
+
+func (s *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.checkSignature(r); err != nil {
+		s.badSignatures++
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+	key, ok := strings.CutPrefix(r.URL.Path, "/"+s.bucket)
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	key = strings.TrimPrefix(key, "/")
+	switch {
+	case r.Method == http.MethodPut:
+		data, err := io.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		s.objects[key] = data
+		s.modTimes[key] = time.Now().UTC().Truncate(time.Second)
+		w.Header().Set("ETag", fmt.Sprintf(`"%x"`, md5.Sum(data)))
+	case r.Method == http.MethodGet && key == "":
+		s.list(w, r.URL.Query())
+	case r.Method == http.MethodGet || r.Method == http.MethodHead:
+		data, ok := s.objects[key]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("ETag", fmt.Sprintf(`"%x"`, md5.Sum(data)))
+		w.Header().Set("Last-Modified", s.modTimes[key].Format(http.TimeFormat))
+		w.Header().Set("Content-Length", fmt.Sprint(len(data)))
+		if r.Method == http.MethodGet {
+			w.Write(data)
+		}
+	case r.Method == http.MethodDelete:
+		delete(s.objects, key)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.Error(w, "unsupported", http.StatusNotImplemented)
+	}
+}
+
+//list serves ListObjectsV2, two keys per page.
+func (s *fakeS3) list(w http.ResponseWriter, query url.Values) {
+	if query.Get("list-type") != "2" {
+		http.Error(w, "list-type 2 expected", http.StatusBadRequest)
+		return
+	}
+	prefix, delimiter := query.Get("prefix"), query.Get("delimiter")
+	var keys []string
+	prefixes := make(map[string]bool)
+	for key := range s.objects {
+		if !strings.HasPrefix(key, prefix) {
+			continue
+		}
+		if i := strings.Index(key[len(prefix):], delimiter); delimiter != "" && i >= 0 {
+			prefixes[key[:len(prefix)+i+1]] = true
+			continue
+		}
+		keys = append(keys, key)
+	}
+	for commonPrefix := range prefixes {
+		keys = append(keys, commonPrefix)
+	}
+	sort.Strings(keys)
+	start := 0
+	if token := query.Get("continuation-token"); token != "" {
+		s.continuationPages++
+		start = sort.SearchStrings(keys, token)
+	}
+	type object struct {
+		Key          string
+		LastModified string
+		ETag         string
+		Size         int
+	}
+	type commonPrefix struct {
+		Prefix string
+	}
+	result := struct {
+		XMLName               xml.Name `xml:"ListBucketResult"`
+		IsTruncated           bool
+		NextContinuationToken string `xml:",omitempty"`
+		Contents              []object
+		CommonPrefixes        []commonPrefix
+	}{}
+	end := start + 2
+	if end < len(keys) {
+		result.IsTruncated = true
+		result.NextContinuationToken = keys[end]
+	} else {
+		end = len(keys)
+	}
+	for _, key := range keys[start:end] {
+		if prefixes[key] {
+			result.CommonPrefixes = append(result.CommonPrefixes, commonPrefix{key})
+			continue
+		}
+		data := s.objects[key]
+		result.Contents = append(result.Contents, object{
+			Key:          key,
+			LastModified: s.modTimes[key].Format(time.RFC3339),
+			ETag:         fmt.Sprintf(`"%x"`, md5.Sum(data)),
+			Size:         len(data),
+		})
+	}
+	xml.NewEncoder(w).Encode(result)
+}
+
+//checkSignature checks the AWS signature version 4 of the request is the
+//one of the fake bucket credentials.
+func (s *fakeS3) checkSignature(r *http.Request) error {
+	auth := r.Header.Get("Authorization")
+	fields := make(map[string]string)
+	for _, field := range strings.Split(strings.TrimPrefix(auth, "AWS4-HMAC-SHA256 "), ", ") {
+		if name, value, ok := strings.Cut(field, "="); ok {
+			fields[name] = value
+		}
+	}
+	amzDate := r.Header.Get("X-Amz-Date")
+	date, err := time.Parse("20060102T150405Z", amzDate)
+	if err != nil || time.Since(date) > 15*time.Minute {
+		return fmt.Errorf("bad X-Amz-Date %q", amzDate)
+	}
+	scope := fmt.Sprintf("%s/%s/s3/aws4_request", date.Format("20060102"), s.region)
+	if fields["Credential"] != s.accessKey+"/"+scope {
+		return fmt.Errorf("bad credential %q", fields["Credential"])
+	}
+	signedHeaders := strings.Split(fields["SignedHeaders"], ";")
+	for _, required := range []string{"host", "x-amz-content-sha256", "x-amz-date"} {
+		if !strings.Contains(";"+fields["SignedHeaders"]+";", ";"+required+";") {
+			return fmt.Errorf("header %v isn't signed", required)
+		}
+	}
+
+	//canonical query, sorted and with spaces encoded as %20
+	query := r.URL.Query()
+	var names []string
+	for name := range query {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var pairs []string
+	for _, name := range names {
+		for _, value := range query[name] {
+			pairs = append(pairs, strings.ReplaceAll(url.QueryEscape(name)+"="+url.QueryEscape(value), "+", "%20"))
+		}
+	}
+	var headers []string
+	for _, name := range signedHeaders {
+		value := r.Header.Get(name)
+		if name == "host" {
+			value = r.Host
+		}
+		headers = append(headers, name+":"+strings.TrimSpace(value))
+	}
+	canonicalRequest := strings.Join([]string{
+		r.Method,
+		r.URL.EscapedPath(),
+		strings.Join(pairs, "&"),
+		strings.Join(headers, "\n"),
+		"",
+		fields["SignedHeaders"],
+		r.Header.Get("X-Amz-Content-Sha256"),
+	}, "\n")
+	hash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(hash[:])
+	key := []byte("AWS4" + s.secretKey)
+	for _, part := range []string{date.Format("20060102"), s.region, "s3", "aws4_request"} {
+		key = hmacSHA256(key, part)
+	}
+	if want := hex.EncodeToString(hmacSHA256(key, stringToSign)); fields["Signature"] != want {
+		return fmt.Errorf("signature %q, want %q", fields["Signature"], want)
+	}
+	return nil
+}
+
+func TestS3Sync(t *testing.T) {
+	testHome(t)
+	bucket, server := startFakeS3(t, "bucket", "eu-west-1", "AKID", "SECRET")
+	backend, err := NewS3Backend(server.URL, "eu-west-1", "bucket", "backups/laptop", "AKID", "SECRET")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	//names that need escaping in the signed path
+	src := path.Join(t.TempDir(), "src")
+	writeFile(t, path.Join(src, "a b.txt"), "a")
+	writeFile(t, path.Join(src, "sub", "c+d.txt"), "c")
+	writeFile(t, path.Join(src, "sub", "e.txt"), "e")
+	writeFile(t, path.Join(src, "sub", "f.txt"), "f")
+	syncer := &Syncer{Remote: "s3", Backend: backend}
+	syncer.Sync(src)
+	if bucket.badSignatures != 0 {
+		t.Fatalf("%v requests with a bad signature", bucket.badSignatures)
+	}
+	want := map[string]string{
+		"backups/laptop/src/a b.txt":     "a",
+		"backups/laptop/src/sub/c+d.txt": "c",
+		"backups/laptop/src/sub/e.txt":   "e",
+		"backups/laptop/src/sub/f.txt":   "f",
+	}
+	if len(bucket.objects) != len(want) {
+		t.Errorf("bucket holds %v objects, want %v", len(bucket.objects), len(want))
+	}
+	for key, content := range want {
+		if string(bucket.objects[key]) != content {
+			t.Errorf("object %q = %q, want %q", key, bucket.objects[key], content)
+		}
+	}
+
+	//listing pages through the results
+	children, err := backend.List("backups/laptop/src/sub/")
+	if err != nil || len(children) != 3 {
+		t.Fatalf("List(\"sub\") = %v, %v; want 3 files", children, err)
+	}
+	if bucket.continuationPages == 0 {
+		t.Error("List didn't ask for the next page")
+	}
+	roots, err := backend.List("")
+	if err != nil || len(roots) != 1 || !roots[0].IsDir || roots[0].ID != "backups/laptop/src/" {
+		t.Errorf("List(\"\") = %+v, %v; want the src folder", roots, err)
+	}
+
+	//stat and download
+	_, fileId, _, _ := ReadChkSum(path.Join(src, "sub", "c+d.txt"), "s3")
+	remoteFile, err := backend.Stat(fileId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if remoteFile.Size != 1 || remoteFile.MD5 != fmt.Sprintf("%x", md5.Sum([]byte("c"))) || remoteFile.ModTime.IsZero() {
+		t.Errorf("Stat(%q) = %+v", fileId, remoteFile)
+	}
+	var data bytes.Buffer
+	if err := backend.Download(fileId, &data); err != nil || data.String() != "c" {
+		t.Errorf("Download(%q) = %q, %v; want \"c\"", fileId, data.String(), err)
+	}
+	if _, err := backend.Stat("backups/laptop/src/missing.txt"); err == nil {
+		t.Error("Stat of a missing object succeeded")
+	}
+
+	//updates replace the object
+	writeFile(t, path.Join(src, "sub", "c+d.txt"), "updated")
+	syncer.Sync(src)
+	if string(bucket.objects[fileId]) != "updated" {
+		t.Errorf("updated object = %q, want \"updated\"", bucket.objects[fileId])
+	}
+	if bucket.badSignatures != 0 {
+		t.Errorf("%v requests with a bad signature", bucket.badSignatures)
+	}
+
+	//requests signed with another secret are refused
+	wrong, err := NewS3Backend(server.URL, "eu-west-1", "bucket", "", "AKID", "WRONG")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := wrong.Upload(&RemoteFile{Name: "x.txt"}, strings.NewReader("x")); err == nil {
+		t.Error("upload signed with a wrong secret succeeded")
+	}
+}
@@ -0,0 +1,256 @@
+/*
+Copyright © 2022 NAME HERE <EMAIL ADDRESS>
+
+*/
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path"
+	"time"
+
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+//sftpBackend stores files in a directory of a SSH server.
+//Ids are slash separated paths relative to the backend root directory.
+type sftpBackend struct {
+	root   string
+	client *sftp.Client
+}
+
+//NewSFTPBackend connects to the given SSH server and returns a Backend that
+//stores files inside its root directory. The private key file is used to
+//authenticate when given, otherwise the keys of the running ssh-agent are
+//used. The server host key must be listed in the known hosts file.
+func NewSFTPBackend(host, user, keyFile, knownHostsFile, root string) (Backend, error) {
+	var auth ssh.AuthMethod
+	if keyFile != "" {
+		key, err := os.ReadFile(keyFile)
+		if err != nil {
+			return nil, err
+		}
+		signer, err := ssh.ParsePrivateKey(key)
+		if err != nil {
+			return nil, fmt.Errorf("unable to parse private key %q: %v", keyFile, err)
+		}
+		auth = ssh.PublicKeys(signer)
+	} else {
+		conn, err := net.Dial("unix", os.Getenv("SSH_AUTH_SOCK"))
+		if err != nil {
+			return nil, fmt.Errorf("no private key given and no ssh-agent running: %v", err)
+		}
+		auth = ssh.PublicKeysCallback(agent.NewClient(conn).Signers)
+	}
+	hostKeyCallback, err := knownhosts.New(knownHostsFile)
+	if err != nil {
+		return nil, err
+	}
+	if _, _, err := net.SplitHostPort(host); err != nil {
+		host = net.JoinHostPort(host, "22")
+	}
+	//reads fail once the server is silent for HTTPIdleTimeout, keepalives
+	//keep an idle connection talking
+	netConn, err := net.DialTimeout("tcp", host, HTTPIdleTimeout)
+	if err != nil {
+		return nil, err
+	}
+	sshConn, channels, requests, err := ssh.NewClientConn(&idleTimeoutConn{Conn: netConn}, host, &ssh.ClientConfig{
+		User:            user,
+		Auth:            []ssh.AuthMethod{auth},
+		HostKeyCallback: hostKeyCallback,
+	})
+	if err != nil {
+		netConn.Close()
+		return nil, err
+	}
+	conn := ssh.NewClient(sshConn, channels, requests)
+	go keepAlive(conn)
+	client, err := sftp.NewClient(conn)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	if err := client.MkdirAll(root); err != nil {
+		client.Close()
+		return nil, err
+	}
+	return &sftpBackend{root: root, client: client}, nil
+}
+
+//keepAlive sends keepalive requests on conn until it's closed, often enough
+//for the server replies to keep the connection from timing out when idle.
+func keepAlive(conn *ssh.Client) {
+	ticker := time.NewTicker(HTTPIdleTimeout / 3)
+	defer ticker.Stop()
+	for range ticker.C {
+		if _, _, err := conn.SendRequest("keepalive@openssh.com", true, nil); err != nil {
+			return
+		}
+	}
+}
+
+func (s *sftpBackend) CreateFolder(f *RemoteFile) (*RemoteFile, error) {
+	id := path.Join(f.Parent, f.Name)
+	if err := s.client.MkdirAll(s.fullPath(id)); err != nil {
+		return nil, err
+	}
+	return s.Stat(id)
+}
+
+func (s *sftpBackend) Upload(f *RemoteFile, r io.Reader) (*RemoteFile, error) {
+	id := path.Join(f.Parent, f.Name)
+	if err := s.write(id, f, r); err != nil {
+		return nil, err
+	}
+	return s.Stat(id)
+}
+
+func (s *sftpBackend) Update(f *RemoteFile, r io.Reader) (*RemoteFile, error) {
+	if _, err := s.client.Stat(s.fullPath(f.ID)); err != nil {
+		return nil, err
+	}
+	if err := s.write(f.ID, f, r); err != nil {
+		return nil, err
+	}
+	return s.Stat(f.ID)
+}
+
+func (s *sftpBackend) Stat(id string) (*RemoteFile, error) {
+	fileStats, err := s.client.Stat(s.fullPath(id))
+	if err != nil {
+		return nil, err
+	}
+	return localRemoteFile(id, fileStats), nil
+}
+
+func (s *sftpBackend) List(parent string) ([]*RemoteFile, error) {
+	entries, err := s.client.ReadDir(s.fullPath(parent))
+	if err != nil {
+		return nil, err
+	}
+	var files []*RemoteFile
+	for _, fileStats := range entries {
+		files = append(files, localRemoteFile(path.Join(parent, fileStats.Name()), fileStats))
+	}
+	return files, nil
+}
+
+func (s *sftpBackend) Delete(id string) error {
+	return s.removeAll(s.fullPath(id))
+}
+
+func (s *sftpBackend) Move(f *RemoteFile) (*RemoteFile, error) {
+	id := path.Join(f.Parent, f.Name)
+	if err := s.client.MkdirAll(path.Dir(s.fullPath(id))); err != nil {
+		return nil, err
+	}
+	if err := s.client.PosixRename(s.fullPath(f.ID), s.fullPath(id)); err != nil {
+		if err := s.client.Rename(s.fullPath(f.ID), s.fullPath(id)); err != nil {
+			return nil, err
+		}
+	}
+	return s.Stat(id)
+}
+
+func (s *sftpBackend) Download(id string, w io.Writer) error {
+	f, err := s.client.Open(s.fullPath(id))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = f.WriteTo(w)
+	return err
+}
+
+//fullPath returns the server path of the given Id.
+func (s *sftpBackend) fullPath(id string) string {
+	return path.Join(s.root, id)
+}
+
+//removeAll removes the given server path and all its children.
+func (s *sftpBackend) removeAll(p string) error {
+	fileStats, err := s.client.Lstat(p)
+	if err != nil {
+		return err
+	}
+	if !fileStats.IsDir() {
+		return s.client.Remove(p)
+	}
+	entries, err := s.client.ReadDir(p)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		if err := s.removeAll(path.Join(p, entry.Name())); err != nil {
+			return err
+		}
+	}
+	return s.client.RemoveDirectory(p)
+}
+
+//write copies r to a temporary file and then renames it to the given Id,
+//so a failed copy never leaves a half written backup behind. The backup
+//keeps the modification time and the mode of f.
+func (s *sftpBackend) write(id string, f *RemoteFile, r io.Reader) error {
+	target := s.fullPath(id)
+	tmpName := path.Join(path.Dir(target), ".dsync-"+path.Base(target))
+	tmp, err := s.client.Create(tmpName)
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.ReadFrom(r); err != nil {
+		tmp.Close()
+		s.client.Remove(tmpName)
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		s.client.Remove(tmpName)
+		return err
+	}
+	mode := f.Mode
+	if mode == 0 {
+		mode = 0644
+	}
+	if err := s.client.Chmod(tmpName, mode); err != nil {
+		s.client.Remove(tmpName)
+		return err
+	}
+	if !f.ModTime.IsZero() {
+		if err := s.client.Chtimes(tmpName, f.ModTime, f.ModTime); err != nil {
+			s.client.Remove(tmpName)
+			return err
+		}
+	}
+	//PosixRename replaces the target, plain SFTP rename fails if it exists
+	//so the target is moved aside first, and put back if the rename fails
+	if err := s.client.PosixRename(tmpName, target); err == nil {
+		return nil
+	}
+	backup := path.Join(path.Dir(target), ".dsync-old-"+path.Base(target))
+	_, err = s.client.Lstat(target)
+	replacing := err == nil
+	if replacing {
+		if err := s.client.Rename(target, backup); err != nil {
+			s.client.Remove(tmpName)
+			return err
+		}
+	}
+	if err := s.client.Rename(tmpName, target); err != nil {
+		if replacing {
+			s.client.Rename(backup, target)
+		}
+		s.client.Remove(tmpName)
+		return err
+	}
+	if replacing {
+		s.client.Remove(backup)
+	}
+	return nil
+}
@@ -0,0 +1,221 @@
+/*
+Copyright © 2022 NAME HERE <EMAIL ADDRESS>
+
+*/
+package cmd
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"net"
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+//startSSHServer starts an in-process SSH server serving the sftp subsystem
+//to the owner of the returned private key file, and returns its address and
+//a known hosts file listing it.
+func startSSHServer(t *testing.T) (addr, keyFile, knownHostsFile string) {
+	t.Helper()
+	hostKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hostSigner, err := ssh.NewSignerFromKey(hostKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	userKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	userPublicKey, err := ssh.NewPublicKey(&userKey.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := &ssh.ServerConfig{
+		PublicKeyCallback: func(conn ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+			if !bytes.Equal(key.Marshal(), userPublicKey.Marshal()) {
+				return nil, os.ErrPermission
+			}
+			return nil, nil
+		},
+	}
+	config.AddHostKey(hostSigner)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go serveSFTP(conn, config)
+		}
+	}()
+
+	dir := t.TempDir()
+	userKeyData, err := x509.MarshalECPrivateKey(userKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyFile = path.Join(dir, "id_ecdsa")
+	writeFile(t, keyFile, string(pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: userKeyData})))
+	addr = listener.Addr().String()
+	knownHostsFile = path.Join(dir, "known_hosts")
+	writeFile(t, knownHostsFile, knownhosts.Line([]string{addr}, hostSigner.PublicKey())+"\n")
+	return addr, keyFile, knownHostsFile
+}
+
+//serveSFTP serves the sftp subsystem on the sessions of an SSH connection.
+func serveSFTP(conn net.Conn, config *ssh.ServerConfig) {
+	_, channels, requests, err := ssh.NewServerConn(conn, config)
+	if err != nil {
+		return
+	}
+	go ssh.DiscardRequests(requests)
+	for newChannel := range channels {
+		if newChannel.ChannelType() != "session" {
+			newChannel.Reject(ssh.UnknownChannelType, "unknown channel type")
+			continue
+		}
+		channel, requests, err := newChannel.Accept()
+		if err != nil {
+			return
+		}
+		go func() {
+			for req := range requests {
+				//the payload is the length prefixed subsystem name
+				ok := req.Type == "subsystem" && string(req.Payload[4:]) == "sftp"
+				req.Reply(ok, nil)
+				if !ok {
+					continue
+				}
+				server, err := sftp.NewServer(channel)
+				if err != nil {
+					channel.Close()
+					return
+				}
+				server.Serve()
+				channel.Close()
+			}
+		}()
+	}
+}
+
+func TestSFTPSync(t *testing.T) {
+	testHome(t)
+	addr, keyFile, knownHostsFile := startSSHServer(t)
+	root := path.Join(t.TempDir(), "backups")
+	backend, err := NewSFTPBackend(addr, "dsync", keyFile, knownHostsFile, root)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	src := path.Join(t.TempDir(), "src")
+	file := path.Join(src, "sub", "secret.txt")
+	writeFile(t, file, "secret")
+	modTime := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	if err := os.Chmod(file, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(file, modTime, modTime); err != nil {
+		t.Fatal(err)
+	}
+	syncer := &Syncer{Remote: "sftp", Backend: backend}
+	syncer.Sync(src)
+
+	data, err := os.ReadFile(path.Join(root, "src", "sub", "secret.txt"))
+	if err != nil || string(data) != "secret" {
+		t.Fatalf("remote file = %q, %v; want \"secret\"", data, err)
+	}
+	_, fileId, _, err := ReadChkSum(file, "sftp")
+	if err != nil || fileId != "src/sub/secret.txt" {
+		t.Fatalf("file Id = %q, %v; want \"src/sub/secret.txt\"", fileId, err)
+	}
+	//the remote copy keeps the source metadata restore and verify use
+	remoteFile, err := backend.Stat(fileId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if remoteFile.Mode != 0600 || !remoteFile.ModTime.Equal(modTime) || remoteFile.Size != 6 {
+		t.Errorf("remote file mode %v, mtime %v, size %v; want -rw------- %v 6", remoteFile.Mode, remoteFile.ModTime, remoteFile.Size, modTime)
+	}
+	children, err := backend.List("src/sub")
+	if err != nil || len(children) != 1 || children[0].ID != fileId || children[0].Mode != 0600 {
+		t.Errorf("List(\"src/sub\") = %+v, %v", children, err)
+	}
+
+	//updates replace the content and the metadata
+	writeFile(t, file, "updated")
+	if err := os.Chmod(file, 0640); err != nil {
+		t.Fatal(err)
+	}
+	syncer.Sync(src)
+	remoteFile, err = backend.Stat(fileId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if remoteFile.Mode != 0640 || remoteFile.Size != 7 {
+		t.Errorf("updated remote file mode %v, size %v; want -rw-r----- 7", remoteFile.Mode, remoteFile.Size)
+	}
+	if data, _ := os.ReadFile(path.Join(root, fileId)); string(data) != "updated" {
+		t.Errorf("updated remote file = %q, want \"updated\"", data)
+	}
+}
+
+func TestSFTPIdleTimeout(t *testing.T) {
+	testHome(t)
+	saved := HTTPIdleTimeout
+	HTTPIdleTimeout = 200 * time.Millisecond
+	defer func() { HTTPIdleTimeout = saved }()
+
+	//a server accepting connections but never answering
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+		}
+	}()
+	_, keyFile, knownHostsFile := startSSHServer(t)
+	start := time.Now()
+	if _, err := NewSFTPBackend(listener.Addr().String(), "dsync", keyFile, knownHostsFile, "backups"); err == nil {
+		t.Error("connected to a silent server")
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("connecting to a silent server took %v", elapsed)
+	}
+
+	//idle connections are kept alive
+	addr, keyFile, knownHostsFile := startSSHServer(t)
+	backend, err := NewSFTPBackend(addr, "dsync", keyFile, knownHostsFile, path.Join(t.TempDir(), "backups"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(3 * HTTPIdleTimeout)
+	if _, err := backend.List(""); err != nil {
+		t.Errorf("List after being idle: %v", err)
+	}
+}
@@ -0,0 +1,269 @@
+/*
+Copyright © 2022 NAME HERE <EMAIL ADDRESS>
+
+*/
+package cmd
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+	"time"
+)
+
+//webdavBackend stores files in a WebDAV server (Nextcloud, ownCloud...).
+//Ids are slash separated paths relative to the backend base URL.
+type webdavBackend struct {
+	baseURL  *url.URL
+	user     string
+	password string
+	client   *http.Client
+}
+
+//NewWebDAVBackend returns a Backend that stores files under the given WebDAV
+//collection URL, user and password are used for basic auth when not empty.
+func NewWebDAVBackend(baseURL, user, password string) (Backend, error) {
+	u, err := url.Parse(strings.TrimSuffix(baseURL, "/") + "/")
+	if err != nil {
+		return nil, err
+	}
+	return &webdavBackend{
+		baseURL:  u,
+		user:     user,
+		password: password,
+		client:   newHTTPClient(),
+	}, nil
+}
+
+func (w *webdavBackend) CreateFolder(f *RemoteFile) (*RemoteFile, error) {
+	id := path.Join(f.Parent, f.Name)
+	res, err := w.do("MKCOL", id+"/", nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	res.Body.Close()
+	//405 Method Not Allowed is returned when the collection already exists
+	if res.StatusCode != http.StatusCreated && res.StatusCode != http.StatusMethodNotAllowed {
+		return nil, fmt.Errorf("MKCOL %q: %v", id, res.Status)
+	}
+	return w.Stat(id)
+}
+
+func (w *webdavBackend) Upload(f *RemoteFile, r io.Reader) (*RemoteFile, error) {
+	id := path.Join(f.Parent, f.Name)
+	if err := w.put(id, r); err != nil {
+		return nil, err
+	}
+	return w.Stat(id)
+}
+
+func (w *webdavBackend) Update(f *RemoteFile, r io.Reader) (*RemoteFile, error) {
+	if _, err := w.Stat(f.ID); err != nil {
+		return nil, err
+	}
+	if err := w.put(f.ID, r); err != nil {
+		return nil, err
+	}
+	return w.Stat(f.ID)
+}
+
+func (w *webdavBackend) Stat(id string) (*RemoteFile, error) {
+	files, err := w.propfind(id, "0")
+	if err != nil {
+		return nil, err
+	}
+	if len(files) == 0 {
+		return nil, fmt.Errorf("PROPFIND %q: empty response", id)
+	}
+	return files[0], nil
+}
+
+func (w *webdavBackend) List(parent string) ([]*RemoteFile, error) {
+	files, err := w.propfind(parent, "1")
+	if err != nil {
+		return nil, err
+	}
+	var children []*RemoteFile
+	for _, f := range files {
+		if f.ID != strings.Trim(parent, "/") {
+			children = append(children, f)
+		}
+	}
+	return children, nil
+}
+
+func (w *webdavBackend) Delete(id string) error {
+	res, err := w.do(http.MethodDelete, id, nil, nil)
+	if err != nil {
+		return err
+	}
+	res.Body.Close()
+	if res.StatusCode/100 != 2 {
+		return fmt.Errorf("DELETE %q: %v", id, res.Status)
+	}
+	return nil
+}
+
+func (w *webdavBackend) Move(f *RemoteFile) (*RemoteFile, error) {
+	id := path.Join(f.Parent, f.Name)
+	header := http.Header{}
+	header.Set("Destination", w.url(id))
+	header.Set("Overwrite", "T")
+	res, err := w.do("MOVE", f.ID, nil, header)
+	if err != nil {
+		return nil, err
+	}
+	res.Body.Close()
+	if res.StatusCode/100 != 2 {
+		return nil, fmt.Errorf("MOVE %q: %v", f.ID, res.Status)
+	}
+	return w.Stat(id)
+}
+
+func (w *webdavBackend) Download(id string, wr io.Writer) error {
+	res, err := w.do(http.MethodGet, id, nil, nil)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("GET %q: %v", id, res.Status)
+	}
+	_, err = io.Copy(wr, res.Body)
+	return err
+}
+
+//put uploads the content of r to the given Id.
+func (w *webdavBackend) put(id string, r io.Reader) error {
+	res, err := w.do(http.MethodPut, id, r, nil)
+	if err != nil {
+		return err
+	}
+	res.Body.Close()
+	if res.StatusCode/100 != 2 {
+		return fmt.Errorf("PUT %q: %v", id, res.Status)
+	}
+	return nil
+}
+
+//propfind returns the properties of the given Id and, with depth "1",
+//of all its children.
+func (w *webdavBackend) propfind(id, depth string) ([]*RemoteFile, error) {
+	header := http.Header{}
+	header.Set("Depth", depth)
+	header.Set("Content-Type", "application/xml")
+	body := strings.NewReader(`<?xml version="1.0" encoding="utf-8"?>
+<d:propfind xmlns:d="DAV:"><d:prop><d:resourcetype/><d:getcontentlength/><d:getlastmodified/><d:getetag/></d:prop></d:propfind>`)
+	res, err := w.do("PROPFIND", id, body, header)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode == http.StatusNotFound {
+		return nil, fmt.Errorf("PROPFIND %q: %w", id, os.ErrNotExist)
+	}
+	if res.StatusCode != http.StatusMultiStatus {
+		return nil, fmt.Errorf("PROPFIND %q: %v", id, res.Status)
+	}
+	var ms davMultistatus
+	if err := xml.NewDecoder(res.Body).Decode(&ms); err != nil {
+		return nil, fmt.Errorf("PROPFIND %q: %v", id, err)
+	}
+	var files []*RemoteFile
+	for _, response := range ms.Responses {
+		f, err := w.fromDavResponse(response)
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, f)
+	}
+	return files, nil
+}
+
+//do sends a request for the given Id to the WebDAV server.
+func (w *webdavBackend) do(method, id string, body io.Reader, header http.Header) (*http.Response, error) {
+	req, err := http.NewRequest(method, w.url(id), body)
+	if err != nil {
+		return nil, err
+	}
+	for key, values := range header {
+		req.Header[key] = values
+	}
+	if w.user != "" || w.password != "" {
+		req.SetBasicAuth(w.user, w.password)
+	}
+	return w.client.Do(req)
+}
+
+//url returns the URL of the given Id, a trailing slash is kept.
+func (w *webdavBackend) url(id string) string {
+	segments := strings.Split(strings.Trim(id, "/"), "/")
+	for i, segment := range segments {
+		segments[i] = url.PathEscape(segment)
+	}
+	u := *w.baseURL
+	u.Path = ""
+	u.RawPath = ""
+	escaped := w.baseURL.EscapedPath() + strings.Join(segments, "/")
+	if strings.HasSuffix(id, "/") {
+		escaped += "/"
+	}
+	return u.String() + escaped
+}
+
+//fromDavResponse converts a PROPFIND response entry to a RemoteFile.
+func (w *webdavBackend) fromDavResponse(response davResponse) (*RemoteFile, error) {
+	href, err := url.Parse(response.Href)
+	if err != nil {
+		return nil, err
+	}
+	id := strings.Trim(strings.TrimPrefix(href.Path, w.baseURL.Path), "/")
+	parent := path.Dir(id)
+	if parent == "." {
+		parent = ""
+	}
+	f := &RemoteFile{
+		ID:     id,
+		Name:   path.Base(id),
+		Parent: parent,
+	}
+	for _, propstat := range response.Propstat {
+		if !strings.Contains(propstat.Status, " 200 ") {
+			continue
+		}
+		f.IsDir = propstat.Prop.ResourceType.Collection != nil
+		f.Size = propstat.Prop.ContentLength
+		f.Revision = strings.Trim(strings.TrimPrefix(propstat.Prop.ETag, "W/"), `"`)
+		if modTime, err := time.Parse(http.TimeFormat, propstat.Prop.LastModified); err == nil {
+			f.ModTime = modTime
+		}
+	}
+	return f, nil
+}
+
+//davMultistatus is the body of a PROPFIND response.
+type davMultistatus struct {
+	Responses []davResponse `xml:"DAV: response"`
+}
+
+type davResponse struct {
+	Href     string        `xml:"DAV: href"`
+	Propstat []davPropstat `xml:"DAV: propstat"`
+}
+
+type davPropstat struct {
+	Status string `xml:"DAV: status"`
+	Prop   struct {
+		ResourceType struct {
+			Collection *struct{} `xml:"DAV: collection"`
+		} `xml:"DAV: resourcetype"`
+		ContentLength int64  `xml:"DAV: getcontentlength"`
+		LastModified  string `xml:"DAV: getlastmodified"`
+		ETag          string `xml:"DAV: getetag"`
+	} `xml:"DAV: prop"`
+}
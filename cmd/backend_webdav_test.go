@@ -0,0 +1,139 @@
+/*
+Copyright © 2022 NAME HERE <EMAIL ADDRESS>
+
+*/
+package cmd
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	"golang.org/x/net/webdav"
+)
+
+func TestWebDAVSync(t *testing.T) {
+	testHome(t)
+	davDir := t.TempDir()
+	server := httptest.NewServer(&webdav.Handler{
+		Prefix:     "/dav",
+		FileSystem: webdav.Dir(davDir),
+		LockSystem: webdav.NewMemLS(),
+	})
+	defer server.Close()
+	//hrefs of the PROPFIND responses are escaped
+	if err := os.Mkdir(path.Join(davDir, "my backups"), 0750); err != nil {
+		t.Fatal(err)
+	}
+	backend, err := NewWebDAVBackend(server.URL+"/dav/my backups", "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	syncer := &Syncer{Remote: "dav", Backend: backend}
+
+	src := path.Join(t.TempDir(), "src")
+	writeFile(t, path.Join(src, "a b.txt"), "a")
+	writeFile(t, path.Join(src, "sub dir", "ü.txt"), "ü")
+	syncer.Sync(src)
+
+	remoteDir := path.Join(davDir, "my backups", "src")
+	for name, content := range map[string]string{"a b.txt": "a", "sub dir/ü.txt": "ü"} {
+		data, err := os.ReadFile(path.Join(remoteDir, name))
+		if err != nil || string(data) != content {
+			t.Errorf("remote %q = %q, %v; want %q", name, data, err, content)
+		}
+	}
+	folderId, err := ReadFolderId(src, "dav")
+	if err != nil || folderId != "src" {
+		t.Fatalf("folder Id of %q = %q, %v; want \"src\"", src, folderId, err)
+	}
+	if !ChkSumFile(path.Join(src, "sub dir", "ü.txt"), "dav") {
+		t.Error("ChkSumFile of a synced file = false")
+	}
+	hash, fileId, revision, err := ReadChkSum(path.Join(src, "sub dir", "ü.txt"), "dav")
+	if err != nil || fileId != "src/sub dir/ü.txt" || revision == "" {
+		t.Fatalf("checksum file of \"ü.txt\" = %q %q %q, %v", hash, fileId, revision, err)
+	}
+
+	folder, err := backend.Stat(folderId)
+	if err != nil || !folder.IsDir || folder.Name != "src" || folder.Parent != "" {
+		t.Fatalf("Stat(%q) = %+v, %v", folderId, folder, err)
+	}
+	children, err := backend.List(folderId)
+	if err != nil || len(children) != 2 {
+		t.Fatalf("List(%q) = %v, %v; want 2 children", folderId, children, err)
+	}
+	for _, child := range children {
+		if child.Parent != "src" {
+			t.Errorf("parent of %q = %q, want \"src\"", child.ID, child.Parent)
+		}
+		switch child.Name {
+		case "a b.txt":
+			if child.IsDir || child.Size != 1 || time.Since(child.ModTime) > time.Hour {
+				t.Errorf("remote file %+v, want a 1 byte file modified now", child)
+			}
+		case "sub dir":
+			if !child.IsDir {
+				t.Errorf("remote %q isn't a folder", child.ID)
+			}
+		default:
+			t.Errorf("unexpected remote file %q", child.ID)
+		}
+	}
+
+	if _, err := backend.Stat("src/missing"); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("Stat of a missing file = %v, want os.ErrNotExist", err)
+	}
+
+	//updates keep the remote file
+	writeFile(t, path.Join(src, "a b.txt"), "updated")
+	syncer.Sync(src)
+	if data, _ := os.ReadFile(path.Join(remoteDir, "a b.txt")); string(data) != "updated" {
+		t.Errorf("updated remote file = %q, want \"updated\"", data)
+	}
+	if _, fileId, _, _ := ReadChkSum(path.Join(src, "a b.txt"), "dav"); fileId != "src/a b.txt" {
+		t.Errorf("updated file Id = %q, want \"src/a b.txt\"", fileId)
+	}
+
+	//a file task is synced to the remote root
+	file := path.Join(t.TempDir(), "single.txt")
+	writeFile(t, file, "single")
+	syncer.Sync(file)
+	if data, _ := os.ReadFile(path.Join(davDir, "my backups", "single.txt")); string(data) != "single" {
+		t.Errorf("remote single file = %q, want \"single\"", data)
+	}
+}
+
+func TestWebDAVStalledServer(t *testing.T) {
+	saved := HTTPIdleTimeout
+	HTTPIdleTimeout = 100 * time.Millisecond
+	defer func() { HTTPIdleTimeout = saved }()
+	stalled := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-stalled
+	}))
+	defer server.Close()
+	defer close(stalled)
+
+	backend, err := NewWebDAVBackend(server.URL, "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan error)
+	go func() {
+		_, err := backend.Stat("file")
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		if err == nil {
+			t.Error("Stat on a stalled server succeeded")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Stat on a stalled server didn't time out")
+	}
+}
@@ -0,0 +1,94 @@
+/*
+Copyright © 2022 NAME HERE <EMAIL ADDRESS>
+
+*/
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+//Conflict policies applied to files modified both locally and in the remote
+//since their last sync, tasks without a conflict policy keep both copies.
+const (
+	ConflictKeepBoth = "keep-both"
+	ConflictLocal    = "local"
+	ConflictRemote   = "remote"
+	ConflictSkip     = "skip"
+)
+
+//ValidConflict checks the given conflict policy is supported.
+func ValidConflict(policy string) bool {
+	switch policy {
+	case "", ConflictKeepBoth, ConflictLocal, ConflictRemote, ConflictSkip:
+		return true
+	}
+	return false
+}
+
+//conflictFile returns the name the copy of a file in conflict in the given
+//remote is saved to when both copies are kept.
+func conflictFile(file, remote string, now time.Time) string {
+	ext := filepath.Ext(file)
+	return fmt.Sprintf("%s.conflict-%s-%s%s", strings.TrimSuffix(file, ext), remote, now.Format("20060102-150405"), ext)
+}
+
+//resolveConflict checks the remote copy of file wasn't modified since its
+//revision was synced, and applies the syncer conflict policy otherwise. It
+//returns true if the local file mustn't be pushed.
+func (s *Syncer) resolveConflict(file, parent, id, revision string) bool {
+	if revision == "" {
+		//synced by a version not keeping remote revisions
+		return false
+	}
+	remoteFile, err := s.Backend.Stat(id)
+	if err != nil {
+		log.Fatalf("Unable to get remote file %q from %v: %v", file, s.Remote, err)
+	}
+	if remoteFile.Revision == "" || remoteFile.Revision == revision {
+		return false
+	}
+
+	switch s.Conflict {
+	case ConflictLocal:
+		fmt.Printf("File %q was modified in %v since its last sync and will be overwritten\n", file, s.Remote)
+		return false
+
+	case ConflictRemote:
+		hash, err := downloadFile(s.Backend, remoteFile, file)
+		if errors.Is(err, errChecksum) {
+			fmt.Printf("File %q was modified in %v since its last sync but the remote copy doesn't match its MD5 checksum, the local copy was kept\n", file, s.Remote)
+			return true
+		}
+		if err != nil {
+			log.Fatalf("Unable to download %q from %v: %v", file, s.Remote, err)
+		}
+		writeChkSum(file, s.Remote, hash, remoteFile)
+		fmt.Printf("File %q was modified in %v since its last sync and was replaced by the remote copy\n", file, s.Remote)
+		return true
+
+	case ConflictSkip:
+		fmt.Printf("File %q was modified both locally and in %v since its last sync and was skipped\n", file, s.Remote)
+		return true
+	}
+
+	remoteCopy := conflictFile(file, s.Remote, time.Now())
+	_, err = downloadFile(s.Backend, remoteFile, remoteCopy)
+	if errors.Is(err, errChecksum) {
+		fmt.Printf("File %q was modified in %v since its last sync but the remote copy doesn't match its MD5 checksum and was skipped\n", file, s.Remote)
+		return true
+	}
+	if err != nil {
+		log.Fatalf("Unable to download %q from %v: %v", file, s.Remote, err)
+	}
+	fmt.Printf("File %q was modified in %v since its last sync, the remote copy was saved to %q\n", file, s.Remote, path.Base(remoteCopy))
+	s.SyncFile(remoteCopy, parent)
+	s.conflictCopies = append(s.conflictCopies, remoteCopy)
+	return false
+}
@@ -0,0 +1,54 @@
+/*
+Copyright © 2022 NAME HERE <EMAIL ADDRESS>
+
+*/
+package cmd
+
+import (
+	"os"
+	"path"
+	"strings"
+	"testing"
+)
+
+func TestConflictKeepBothMirror(t *testing.T) {
+	testHome(t)
+	s := startFakeDrive(t)
+	src := path.Join(t.TempDir(), "src")
+	writeFile(t, path.Join(src, "a.txt"), "a")
+	runDsync(t, "sync", src, "--mirror", MirrorTrash)
+	_, aId, _, _ := ReadChkSum(path.Join(src, "a.txt"), DefaultRemote)
+
+	//modified both in the remote and locally
+	backend := GetBackend(GetRemote(DefaultRemote), false)
+	if _, err := backend.Update(&RemoteFile{ID: aId}, strings.NewReader("remote")); err != nil {
+		t.Fatal(err)
+	}
+	writeFile(t, path.Join(src, "a.txt"), "local")
+	runDsync(t, "sync", src, "--mirror", MirrorTrash, "--conflict", ConflictKeepBoth)
+
+	entries, err := os.ReadDir(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var copyName string
+	for _, entry := range entries {
+		if strings.HasPrefix(entry.Name(), "a.conflict-drive-") {
+			copyName = entry.Name()
+		}
+	}
+	if copyName == "" {
+		t.Fatalf("no conflict copy in %v", entries)
+	}
+	want := map[string]string{"src/": "", "src/a.txt": "local", "src/" + copyName: "remote"}
+	checkTree(t, s, want)
+	checkState(t, s, path.Join(src, copyName))
+
+	//the next sync has nothing to do
+	files := len(s.Files())
+	runDsync(t, "sync", src, "--mirror", MirrorTrash, "--conflict", ConflictKeepBoth)
+	checkTree(t, s, want)
+	if len(s.Files()) != files {
+		t.Errorf("sync after the conflict created %v remote files", len(s.Files())-files)
+	}
+}
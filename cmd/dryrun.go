@@ -0,0 +1,61 @@
+/*
+Copyright © 2022 NAME HERE <EMAIL ADDRESS>
+
+*/
+package cmd
+
+import (
+	"fmt"
+	"log"
+	"os"
+)
+
+//syncPlan counts what a dry run would sync.
+type syncPlan struct {
+	folders        int
+	uploads        int
+	uploadBytes    int64
+	updates        int
+	updateBytes    int64
+	moves          int
+	links          int
+	unchanged      int
+	unchangedBytes int64
+}
+
+//printPlan prints the totals of a dry run of the given task path.
+func (s *Syncer) printPlan(file string) {
+	p := s.plan
+	fmt.Printf("Dry run of %q to %v:\n", file, s.Remote)
+	fmt.Printf("  %v folders to create\n", p.folders)
+	fmt.Printf("  %v files to upload (%v)\n", p.uploads, formatSize(p.uploadBytes))
+	fmt.Printf("  %v files to update (%v)\n", p.updates, formatSize(p.updateBytes))
+	fmt.Printf("  %v files to move\n", p.moves)
+	fmt.Printf("  %v symbolic links to upload\n", p.links)
+	fmt.Printf("  %v files unchanged (%v)\n", p.unchanged, formatSize(p.unchangedBytes))
+	fmt.Printf("  %v to transfer\n", formatSize(p.uploadBytes+p.updateBytes))
+	s.plan = syncPlan{}
+}
+
+//fileSize returns the size of the given file.
+func fileSize(file string) int64 {
+	fileStats, err := os.Stat(file)
+	if err != nil {
+		log.Fatalf("Unable to get file %q stats: %v", file, err)
+	}
+	return fileStats.Size()
+}
+
+//formatSize returns a human readable size.
+func formatSize(size int64) string {
+	const unit = 1024
+	if size < unit {
+		return fmt.Sprintf("%d B", size)
+	}
+	div, exp := int64(unit), 0
+	for n := size / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(size)/float64(div), "KMGTPE"[exp])
+}
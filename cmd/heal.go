@@ -0,0 +1,93 @@
+/*
+Copyright © 2022 NAME HERE <EMAIL ADDRESS>
+
+*/
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+)
+
+//Untrasher is implemented by backends able to restore trashed files and
+//folders, see Trasher.
+type Untrasher interface {
+	Untrash(id string) error
+}
+
+//healFolder checks the remote folder of dir still exists. A trashed folder
+//is restored when the backend can, otherwise it's considered gone. It
+//returns the folder Id, or an empty string when the folder is gone and has
+//to be created again.
+func (s *Syncer) healFolder(dir, folderId string) string {
+	if !s.healRemote(dir, folderId, true) {
+		return folderId
+	}
+	RemoveState(dir, s.Remote)
+	return ""
+}
+
+//healFile checks the remote copy of file inside the remote folder parent
+//still exists. A trashed copy is restored when the backend can, otherwise
+//the sync state of the gone copy is removed so that the file is uploaded
+//again.
+func (s *Syncer) healFile(file, parent string) {
+	_, fileId, _, err := ReadChkSum(file, s.Remote)
+	if err != nil {
+		return
+	}
+	if parent != "" {
+		//a listing of the parent saves a call by synced file
+		children, ok := s.remoteChildren[parent]
+		if !ok {
+			remoteFiles, err := s.Backend.List(parent)
+			if err != nil {
+				log.Fatalf("Unable to list remote folder of %q: %v", file, err)
+			}
+			children = make(map[string]bool)
+			for _, remoteFile := range remoteFiles {
+				children[remoteFile.ID] = true
+			}
+			s.remoteChildren[parent] = children
+		}
+		if children[fileId] {
+			return
+		}
+	}
+	if s.healRemote(file, fileId, false) {
+		RemoveState(file, s.Remote)
+	}
+}
+
+//healRemote stats the remote copy of the given file or folder and restores
+//it from the trash when the backend can. It returns whether the remote copy
+//is gone.
+func (s *Syncer) healRemote(file, id string, isFolder bool) bool {
+	kind := "file"
+	if isFolder {
+		kind = "folder"
+	}
+	remoteFile, err := s.Backend.Stat(id)
+	if errors.Is(err, os.ErrNotExist) {
+		fmt.Printf("Remote %v of %q was deleted from %v and is synced again\n", kind, file, s.Remote)
+		return true
+	}
+	if err != nil {
+		log.Fatalf("Unable to get remote %v of %q: %v", kind, file, err)
+	}
+	if !remoteFile.Trashed {
+		return false
+	}
+	untrasher, ok := s.Backend.(Untrasher)
+	if !ok {
+		fmt.Printf("Remote %v of %q is in the trash of %v and is synced again\n", kind, file, s.Remote)
+		return true
+	}
+	if err := untrasher.Untrash(id); err != nil {
+		log.Fatalf("Unable to restore remote %v of %q from the trash: %v", kind, file, err)
+	}
+	fmt.Printf("Remote %v of %q was restored from the trash of %v\n", kind, file, s.Remote)
+	return false
+}
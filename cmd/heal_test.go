@@ -0,0 +1,98 @@
+/*
+Copyright © 2022 NAME HERE <EMAIL ADDRESS>
+
+*/
+package cmd
+
+import (
+	"os"
+	"path"
+	"reflect"
+	"testing"
+)
+
+func TestHealDrive(t *testing.T) {
+	testHome(t)
+	s := startFakeDrive(t)
+	src := path.Join(t.TempDir(), "src")
+	writeFile(t, path.Join(src, "a.txt"), "a")
+	writeFile(t, path.Join(src, "c.txt"), "c")
+	writeFile(t, path.Join(src, "sub", "b.txt"), "b")
+	runDsync(t, "add", src)
+	runDsync(t, "all")
+	subId, _ := ReadFolderId(path.Join(src, "sub"), DefaultRemote)
+	_, aId, _, _ := ReadChkSum(path.Join(src, "a.txt"), DefaultRemote)
+	_, cId, _, _ := ReadChkSum(path.Join(src, "c.txt"), DefaultRemote)
+	files := len(s.Files())
+
+	backend := GetBackend(GetRemote(DefaultRemote), false)
+	for _, id := range []string{subId, aId} {
+		if err := backend.(Trasher).Trash(id); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := backend.Delete(cId); err != nil {
+		t.Fatal(err)
+	}
+	runDsync(t, "all")
+	checkTree(t, s, map[string]string{"src/": "", "src/a.txt": "a", "src/c.txt": "c", "src/sub/": "", "src/sub/b.txt": "b"})
+	//trashed copies are restored, the deleted one is uploaded again
+	for _, id := range []string{subId, aId} {
+		if f, ok := s.Lookup(id); !ok || f.Trashed {
+			t.Errorf("remote copy %v = %+v, %v; want it restored from the trash", id, f, ok)
+		}
+	}
+	if folderId, _ := ReadFolderId(path.Join(src, "sub"), DefaultRemote); folderId != subId {
+		t.Errorf("restored folder Id = %v, want %v", folderId, subId)
+	}
+	//the deleted copy is gone from the fake Drive
+	if len(s.Files()) != files {
+		t.Errorf("healing created %v remote files, want the deleted one only", len(s.Files())-files+1)
+	}
+	for _, file := range []string{"a.txt", "c.txt", "sub/b.txt"} {
+		checkState(t, s, path.Join(src, file))
+	}
+}
+
+func TestHealLocal(t *testing.T) {
+	testHome(t)
+	remoteDir := t.TempDir()
+	runDsync(t, "remote", "add", "local", "--type", "local", "--path", remoteDir)
+	src := path.Join(t.TempDir(), "src")
+	writeFile(t, path.Join(src, "a.txt"), "a")
+	writeFile(t, path.Join(src, "sub", "b.txt"), "b")
+	runDsync(t, "add", src, "--dest", "local")
+	runDsync(t, "all")
+	subId, _ := ReadFolderId(path.Join(src, "sub"), "local")
+
+	//the local backend can't restore from its trash, the folder is synced
+	//again
+	if err := NewLocalBackend(remoteDir).(Trasher).Trash(subId); err != nil {
+		t.Fatal(err)
+	}
+	runDsync(t, "all")
+	entries, err := os.ReadDir(path.Join(remoteDir, "src"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, entry := range entries {
+		names = append(names, entry.Name())
+	}
+	if want := []string{"a.txt", "sub"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("remote folder holds %v, want %v", names, want)
+	}
+	if data, err := os.ReadFile(path.Join(remoteDir, "src", "sub", "b.txt")); err != nil || string(data) != "b" {
+		t.Errorf("synced again \"sub/b.txt\" = %q, %v; want \"b\"", data, err)
+	}
+	if _, fileId, _, err := ReadChkSum(path.Join(src, "sub", "b.txt"), "local"); err != nil || fileId != "src/sub/b.txt" {
+		t.Errorf("sub/b.txt Id = %q, %v; want \"src/sub/b.txt\"", fileId, err)
+	}
+
+	//nothing is left to heal
+	runDsync(t, "all")
+	trashed, err := os.ReadDir(path.Join(remoteDir, localTrash))
+	if err != nil || len(trashed) != 1 {
+		t.Errorf("trash holds %v, %v; want the trashed folder only", trashed, err)
+	}
+}
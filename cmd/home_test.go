@@ -0,0 +1,57 @@
+/*
+Copyright © 2022 NAME HERE <EMAIL ADDRESS>
+
+*/
+package cmd
+
+import (
+	"os"
+	"path"
+	"testing"
+)
+
+//testHome points the dsync files to a temporary home dir for the length of
+//the test, and returns it.
+func testHome(t *testing.T) string {
+	t.Helper()
+	home := t.TempDir()
+	vars := map[*string]string{
+		&UserHome:         home,
+		&TasksFile:        path.Join(home, ".dsync/tasks.dsync"),
+		&RemotesFile:      path.Join(home, ".dsync/remotes.json"),
+		&StateFile:        path.Join(home, ".dsync/state.json"),
+		&StateJournal:     path.Join(home, ".dsync/state.journal"),
+		&legacyChangesDir: path.Join(home, ".dsync/changes"),
+	}
+	for v, value := range vars {
+		v := v
+		saved := *v
+		*v = value
+		t.Cleanup(func() { *v = saved })
+	}
+	if err := os.MkdirAll(path.Join(home, ".dsync"), 0750); err != nil {
+		t.Fatal(err)
+	}
+	resetState()
+	t.Cleanup(resetState)
+	return home
+}
+
+//resetState drops the loaded sync state, the next call reads it again.
+func resetState() {
+	if syncState != nil {
+		syncState.journal.Close()
+		syncState = nil
+	}
+}
+
+//writeFile creates file and its parent dirs with the given content.
+func writeFile(t *testing.T, file, content string) {
+	t.Helper()
+	if err := os.MkdirAll(path.Dir(file), 0750); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(file, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
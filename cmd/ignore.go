@@ -0,0 +1,197 @@
+/*
+Copyright © 2022 NAME HERE <EMAIL ADDRESS>
+
+*/
+package cmd
+
+import (
+	"bufio"
+	"errors"
+	"log"
+	"os"
+	"path"
+	"strings"
+)
+
+//IgnoreFile is the name of the files holding the patterns of the files and
+//folders that aren't synced, in the gitignore format.
+const IgnoreFile = ".dsyncignore"
+
+//DefaultIgnore are the patterns applied before any other: dotfiles and
+//editor backups aren't synced unless they are included again.
+var DefaultIgnore = []string{".*", "*~"}
+
+//ignoreRule is a parsed gitignore pattern.
+type ignoreRule struct {
+	//base is the folder the pattern is relative to
+	base     string
+	segments []string
+	negate   bool
+	dirOnly  bool
+}
+
+//Ignorer decides which files and folders inside a task are synced, using
+//the gitignore semantics: the last matching pattern wins, "!" includes a
+//file again, a trailing "/" only matches folders and "**" matches any
+//number of folders.
+type Ignorer struct {
+	root     string
+	defaults []ignoreRule
+	task     []ignoreRule
+	//rules of the ignore file of every folder read so far
+	dirRules map[string][]ignoreRule
+}
+
+//NewIgnorer returns an Ignorer for the task at root. The task exclude and
+//include patterns are applied after the ignore files, include patterns are
+//negated exclude patterns.
+func NewIgnorer(root string, exclude, include []string) *Ignorer {
+	ig := &Ignorer{root: root, dirRules: make(map[string][]ignoreRule)}
+	for _, pattern := range DefaultIgnore {
+		if rule, ok := parseIgnoreRule(root, pattern); ok {
+			ig.defaults = append(ig.defaults, rule)
+		}
+	}
+	for _, pattern := range exclude {
+		if rule, ok := parseIgnoreRule(root, pattern); ok {
+			ig.task = append(ig.task, rule)
+		}
+	}
+	for _, pattern := range include {
+		if rule, ok := parseIgnoreRule(root, pattern); ok {
+			rule.negate = !rule.negate
+			ig.task = append(ig.task, rule)
+		}
+	}
+	return ig
+}
+
+//parseIgnoreRule parses a line of an ignore file found in base. It returns
+//false for blank lines and comments.
+func parseIgnoreRule(base, pattern string) (ignoreRule, bool) {
+	pattern = strings.TrimRight(pattern, " \t\r")
+	if pattern == "" || strings.HasPrefix(pattern, "#") {
+		return ignoreRule{}, false
+	}
+	rule := ignoreRule{base: base}
+	if strings.HasPrefix(pattern, "!") {
+		rule.negate = true
+		pattern = pattern[1:]
+	} else if strings.HasPrefix(pattern, `\!`) || strings.HasPrefix(pattern, `\#`) {
+		pattern = pattern[1:]
+	}
+	if strings.HasSuffix(pattern, "/") {
+		rule.dirOnly = true
+		pattern = strings.TrimRight(pattern, "/")
+	}
+	if pattern == "" {
+		return ignoreRule{}, false
+	}
+	if !strings.Contains(pattern, "/") {
+		//patterns without a slash match at any depth
+		pattern = "**/" + pattern
+	}
+	rule.segments = strings.Split(strings.TrimPrefix(pattern, "/"), "/")
+	return rule, true
+}
+
+//match checks the rule matches the given file or folder.
+func (r ignoreRule) match(file string, isDir bool) bool {
+	if r.dirOnly && !isDir {
+		return false
+	}
+	if !strings.HasPrefix(file, r.base+"/") {
+		return false
+	}
+	return matchSegments(r.segments, strings.Split(strings.TrimPrefix(file, r.base+"/"), "/"))
+}
+
+//matchSegments matches path segments against pattern segments, "**"
+//matches any number of segments.
+func matchSegments(pattern, segments []string) bool {
+	if len(pattern) == 0 {
+		return len(segments) == 0
+	}
+	if pattern[0] == "**" {
+		for i := 0; i <= len(segments); i++ {
+			if matchSegments(pattern[1:], segments[i:]) {
+				return true
+			}
+		}
+		return false
+	}
+	if len(segments) == 0 {
+		return false
+	}
+	if ok, _ := path.Match(pattern[0], segments[0]); !ok {
+		return false
+	}
+	return matchSegments(pattern[1:], segments[1:])
+}
+
+//rules returns the rules of the ignore file of the given folder.
+func (ig *Ignorer) rules(dir string) []ignoreRule {
+	if rules, ok := ig.dirRules[dir]; ok {
+		return rules
+	}
+	var rules []ignoreRule
+	f, err := os.Open(path.Join(dir, IgnoreFile))
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		log.Fatalf("Unable to read %q: %v", path.Join(dir, IgnoreFile), err)
+	}
+	if err == nil {
+		defer f.Close()
+		scanner := bufio.NewScanner(f)
+		for scanner.Scan() {
+			if rule, ok := parseIgnoreRule(dir, scanner.Text()); ok {
+				rules = append(rules, rule)
+			}
+		}
+		if err := scanner.Err(); err != nil {
+			log.Fatalf("Unable to read %q: %v", path.Join(dir, IgnoreFile), err)
+		}
+	}
+	ig.dirRules[dir] = rules
+	return rules
+}
+
+//Ignored checks the given file or folder isn't synced. Folders are walked
+//from the task root, so the folders holding file are expected not to be
+//ignored. Sync state files are always ignored.
+func (ig *Ignorer) Ignored(file string, isDir bool) bool {
+	if file == ig.root {
+		return false
+	}
+	if isSyncState(path.Base(file)) {
+		return true
+	}
+	rules := append([]ignoreRule{}, ig.defaults...)
+	var dirs []string
+	for dir := path.Dir(file); strings.HasPrefix(dir+"/", ig.root+"/"); dir = path.Dir(dir) {
+		dirs = append([]string{dir}, dirs...)
+		if dir == ig.root {
+			break
+		}
+	}
+	for _, dir := range dirs {
+		rules = append(rules, ig.rules(dir)...)
+	}
+	rules = append(rules, ig.task...)
+
+	ignored := false
+	for _, rule := range rules {
+		if rule.match(file, isDir) {
+			ignored = !rule.negate
+		}
+	}
+	return ignored
+}
+
+//isSyncState checks the given file name is a checksum or folder Id file
+//written by older versions or a temporary file written by dsync.
+func isSyncState(name string) bool {
+	if !strings.HasPrefix(name, ".") {
+		return false
+	}
+	return strings.HasSuffix(name, ".sha256sum") || strings.HasSuffix(name, ".dsync") || strings.HasPrefix(name, ".dsync-")
+}
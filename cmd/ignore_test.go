@@ -0,0 +1,52 @@
+/*
+Copyright © 2022 NAME HERE <EMAIL ADDRESS>
+
+*/
+package cmd
+
+import (
+	"path"
+	"testing"
+)
+
+func TestIgnorerIgnored(t *testing.T) {
+	root := path.Join(t.TempDir(), "src")
+	writeFile(t, path.Join(root, IgnoreFile), "# comment\n*.log\n!keep.log\nbuild/\n/top.txt\ndocs/**/*.tmp\n")
+	//nested ignore files come after the ones of their parents
+	writeFile(t, path.Join(root, "sub", IgnoreFile), "!*.log\nnotes.txt\n")
+	ig := NewIgnorer(root, []string{"secret*"}, []string{"secret-ok", ".env"})
+
+	for _, test := range []struct {
+		file  string
+		isDir bool
+		want  bool
+	}{
+		{"", true, false},
+		{"a.txt", false, false},
+		{"a.log", false, true},
+		{"deep/a.log", false, true},
+		{"keep.log", false, false},
+		{"sub/a.log", false, false},
+		{"sub/deep/a.log", false, false},
+		{"build", true, true},
+		{"build", false, false},
+		{"sub/build", true, true},
+		{"top.txt", false, true},
+		{"sub/top.txt", false, false},
+		{"docs/a.tmp", false, true},
+		{"docs/x/y/a.tmp", false, true},
+		{"other/a.tmp", false, false},
+		{"notes.txt", false, false},
+		{"sub/notes.txt", false, true},
+		{".hidden", false, true},
+		{"a.txt~", false, true},
+		{"secret.txt", false, true},
+		{"secret-ok", false, false},
+		{".env", false, false},
+		{".a.txt.sha256sum", false, true},
+	} {
+		if ignored := ig.Ignored(path.Join(root, test.file), test.isDir); ignored != test.want {
+			t.Errorf("Ignored(%q, %v) = %v, want %v", test.file, test.isDir, ignored, test.want)
+		}
+	}
+}
@@ -6,8 +6,6 @@ package cmd
 
 import (
 	"fmt"
-	"log"
-	"os"
 	"os/exec"
 	"strings"
 
@@ -21,12 +19,50 @@ var listCmd = &cobra.Command{
 	Long: `List all sync tasks:
 "dsync list".`,
 	Run: func(cmd *cobra.Command, args []string) {
-		listFileData, err := os.ReadFile(TasksFile)
-		if err != nil {
-			log.Fatalf("Unable to read tasks list file: %v", err)
-		}
 		fmt.Println("Tasks List:")
-		fmt.Println(string(listFileData))
+		for _, task := range GetTasks() {
+			fmt.Printf("%v -> %v", task.Path, strings.Join(task.Remotes(), ", "))
+			for _, remoteName := range task.Remotes() {
+				if GetRemote(remoteName).Type != RemoteDrive {
+					continue
+				}
+				if task.SharedDrive != "" {
+					fmt.Printf(" (shared drive: %v)", task.sharedDriveName())
+				} else {
+					fmt.Print(" (My Drive)")
+				}
+				break
+			}
+			if task.RemotePath != "" {
+				fmt.Printf(" (remote path: %v)", task.RemotePath)
+			}
+			if task.Mirror != "" {
+				fmt.Printf(" (mirror: %v)", task.Mirror)
+			}
+			if task.Conflict != "" {
+				fmt.Printf(" (conflict: %v)", task.Conflict)
+			}
+			if len(task.Exclude) > 0 {
+				fmt.Printf(" (exclude: %v)", strings.Join(task.Exclude, " "))
+			}
+			if len(task.Include) > 0 {
+				fmt.Printf(" (include: %v)", strings.Join(task.Include, " "))
+			}
+			if task.Symlinks != "" {
+				fmt.Printf(" (symlinks: %v)", task.Symlinks)
+			}
+			if task.TwoWay {
+				fmt.Print(" (two-way)")
+			}
+			if task.Snapshot {
+				fmt.Print(" (snapshot)")
+			}
+			if task.Retention != nil {
+				fmt.Printf(" (keep: %v)", task.Retention)
+			}
+			fmt.Println()
+		}
+		fmt.Println()
 		listCrontab := exec.Command("crontab", "-l")
 		filterCrontab := exec.Command("grep", "dsync")
 
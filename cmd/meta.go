@@ -0,0 +1,48 @@
+/*
+Copyright © 2022 NAME HERE <EMAIL ADDRESS>
+
+*/
+package cmd
+
+import (
+	"errors"
+	"log"
+	"os"
+)
+
+//localMeta returns a RemoteFile holding the modification time, the mode
+//and the owner of the given local file.
+func localMeta(file string) *RemoteFile {
+	fileStats, err := os.Stat(file)
+	if err != nil {
+		log.Fatalf("Unable to get file %q stats: %v", file, err)
+	}
+	f := &RemoteFile{
+		ModTime: fileStats.ModTime(),
+		Mode:    fileStats.Mode().Perm() | fileStats.Mode()&(os.ModeSetuid|os.ModeSetgid|os.ModeSticky),
+	}
+	f.UID, f.GID = fileOwner(fileStats)
+	return f
+}
+
+//applyMeta sets the modification time, the mode and, when allowed, the
+//owner a remote file keeps to the given local file. Nothing is set for
+//backends that don't keep the metadata of synced files.
+func applyMeta(file string, remoteFile *RemoteFile) error {
+	if remoteFile.Mode == 0 {
+		return nil
+	}
+	if remoteFile.UID >= 0 && remoteFile.GID >= 0 {
+		//only root can give files away, other users keep their files
+		if err := os.Lchown(file, remoteFile.UID, remoteFile.GID); err != nil && !errors.Is(err, os.ErrPermission) {
+			return err
+		}
+	}
+	if err := os.Chmod(file, remoteFile.Mode); err != nil {
+		return err
+	}
+	if remoteFile.ModTime.IsZero() {
+		return nil
+	}
+	return os.Chtimes(file, remoteFile.ModTime, remoteFile.ModTime)
+}
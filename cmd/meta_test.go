@@ -0,0 +1,58 @@
+/*
+Copyright © 2022 NAME HERE <EMAIL ADDRESS>
+
+*/
+package cmd
+
+import (
+	"os"
+	"path"
+	"testing"
+	"time"
+)
+
+func TestMetaRoundTrip(t *testing.T) {
+	testHome(t)
+	startFakeDrive(t)
+	runDsync(t, "remote", "add", "local", "--type", "local", "--path", t.TempDir())
+	modTime := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	for _, remoteName := range []string{DefaultRemote, "local"} {
+		src := path.Join(t.TempDir(), "src")
+		file := path.Join(src, "secret.txt")
+		writeFile(t, file, "secret")
+		if err := os.Chmod(file, 0600); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(file, modTime, modTime); err != nil {
+			t.Fatal(err)
+		}
+		runDsync(t, "add", src, "--dest", remoteName)
+		runDsync(t, "all")
+
+		//stored on upload
+		_, fileId, _, err := ReadChkSum(file, remoteName)
+		if err != nil {
+			t.Fatal(err)
+		}
+		remoteFile, err := GetBackend(GetRemote(remoteName), false).Stat(fileId)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if remoteFile.Mode != 0600 || !remoteFile.ModTime.Equal(modTime) {
+			t.Errorf("%v: remote file mode %v, mtime %v; want -rw------- %v", remoteName, remoteFile.Mode, remoteFile.ModTime, modTime)
+		}
+
+		//put back on restore
+		if err := os.Remove(file); err != nil {
+			t.Fatal(err)
+		}
+		runDsync(t, "restore", file)
+		fileStats, err := os.Stat(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if fileStats.Mode().Perm() != 0600 || !fileStats.ModTime().Equal(modTime) {
+			t.Errorf("%v: restored file mode %v, mtime %v; want -rw------- %v", remoteName, fileStats.Mode(), fileStats.ModTime(), modTime)
+		}
+	}
+}
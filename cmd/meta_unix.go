@@ -0,0 +1,20 @@
+//go:build !windows
+
+/*
+Copyright © 2022 NAME HERE <EMAIL ADDRESS>
+
+*/
+package cmd
+
+import (
+	"os"
+	"syscall"
+)
+
+//fileOwner returns the user and group Ids owning a file, or -1.
+func fileOwner(fileStats os.FileInfo) (uid, gid int) {
+	if stat, ok := fileStats.Sys().(*syscall.Stat_t); ok {
+		return int(stat.Uid), int(stat.Gid)
+	}
+	return -1, -1
+}
@@ -0,0 +1,322 @@
+/*
+Copyright © 2022 NAME HERE <EMAIL ADDRESS>
+
+*/
+package cmd
+
+import (
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+//legacyChangesDir holds the changes page tokens written by older versions.
+var legacyChangesDir = path.Join(UserHome, ".dsync/changes")
+
+//sidecar is a checksum or folder Id file written next to a synced file or
+//folder by older versions.
+type sidecar struct {
+	file   string
+	source string
+	remote string
+	isDir  bool
+}
+
+//parseSidecar returns the sidecar of the given file name in dir, names
+//are "." + source name + "@" + remote unless it's the default remote, then
+//".sha256sum" for files and ".dsync" for folders.
+func parseSidecar(dir, name string, remotes map[string]*Remote) (sidecar, bool) {
+	if !strings.HasPrefix(name, ".") {
+		return sidecar{}, false
+	}
+	sc := sidecar{file: path.Join(dir, name), remote: DefaultRemote}
+	var source string
+	switch {
+	case strings.HasSuffix(name, ".sha256sum"):
+		source = strings.TrimSuffix(name[1:], ".sha256sum")
+	case strings.HasSuffix(name, ".dsync"):
+		source = strings.TrimSuffix(name[1:], ".dsync")
+		sc.isDir = true
+	default:
+		return sidecar{}, false
+	}
+	if source == "" {
+		return sidecar{}, false
+	}
+	//sidecars of other remotes look like default remote ones of a file
+	//named "source@remote"
+	if i := strings.LastIndex(source, "@"); i > 0 {
+		if _, err := os.Lstat(path.Join(dir, source)); os.IsNotExist(err) {
+			if _, ok := remotes[source[i+1:]]; ok {
+				sc.remote = source[i+1:]
+				source = source[:i]
+			}
+		}
+	}
+	sc.source = path.Join(dir, source)
+	return sc, true
+}
+
+//entry returns the sync state kept by the sidecar.
+func (sc sidecar) entry() (*StateEntry, error) {
+	data, err := os.ReadFile(sc.file)
+	if err != nil {
+		return nil, err
+	}
+	sidecarStats, err := os.Stat(sc.file)
+	if err != nil {
+		return nil, err
+	}
+	entry := &StateEntry{IsDir: sc.isDir, Synced: sidecarStats.ModTime()}
+	if sc.isDir {
+		entry.ID = strings.TrimSpace(string(data))
+	} else {
+		if len(data) < 65 {
+			return nil, fmt.Errorf("malformed checksum file %q", sc.file)
+		}
+		entry.Hash = string(data[:64])
+		entry.ID, entry.Revision, _ = strings.Cut(string(data[65:]), "\n")
+	}
+	if entry.ID == "" {
+		return nil, fmt.Errorf("no remote Id in %q", sc.file)
+	}
+	if sourceStats, err := os.Lstat(sc.source); err == nil {
+		entry.ModTime = sourceStats.ModTime()
+		if !sc.isDir {
+			entry.Size = sourceStats.Size()
+		}
+	}
+	return entry, nil
+}
+
+//findSidecars returns the sidecars of the task at taskPath, including the
+//one of the task path itself next to it.
+func findSidecars(taskPath string, remotes map[string]*Remote) ([]sidecar, error) {
+	var sidecars []sidecar
+	readDir := func(dir string) error {
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			return err
+		}
+		for _, entry := range entries {
+			if entry.IsDir() {
+				continue
+			}
+			sc, ok := parseSidecar(dir, entry.Name(), remotes)
+			if ok && (dir != path.Dir(taskPath) || sc.source == taskPath) {
+				sidecars = append(sidecars, sc)
+			}
+		}
+		return nil
+	}
+	if err := readDir(path.Dir(taskPath)); err != nil {
+		return nil, err
+	}
+	taskStats, err := os.Lstat(taskPath)
+	if err != nil || !taskStats.IsDir() {
+		return sidecars, nil
+	}
+	err = filepath.WalkDir(taskPath, func(dir string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !entry.IsDir() {
+			return nil
+		}
+		return readDir(dir)
+	})
+	return sidecars, err
+}
+
+//staleCopy is the remote copy of a file or folder kept by a sidecar while
+//a sync since the upgrade uploaded another copy, it's not in the sync state.
+type staleCopy struct {
+	source string
+	remote string
+	id     string
+}
+
+//legacyState returns the operations importing the sidecars and the changes
+//page tokens of the task at taskPath into the sync state, the imported
+//files and the remote copies orphaned by states written since the upgrade,
+//those are kept. The task must be in the sync state.
+func (st *stateStore) legacyState(taskPath string, remotes map[string]*Remote) ([]stateOp, []string, []staleCopy) {
+	sidecars, err := findSidecars(taskPath, remotes)
+	if err != nil {
+		log.Fatalf("Unable to read the sync state files of %q: %v", taskPath, err)
+	}
+	var ops []stateOp
+	var imported []string
+	var staleCopies []staleCopy
+	for _, sc := range sidecars {
+		entry, err := sc.entry()
+		if err != nil {
+			fmt.Printf("Skipped %q: %v\n", sc.file, err)
+			continue
+		}
+		imported = append(imported, sc.file)
+		if current, ok := st.get(sc.source, sc.remote); ok {
+			if current.ID != entry.ID {
+				staleCopies = append(staleCopies, staleCopy{source: sc.source, remote: sc.remote, id: entry.ID})
+			}
+			continue
+		}
+		ops = append(ops, st.put(sc.source, sc.remote, entry)...)
+	}
+
+	var tokenFiles []string
+	for name := range remotes {
+		tokenFiles = append(tokenFiles, legacyChangesTokenFile(taskPath, name))
+	}
+	if _, ok := remotes[DefaultRemote]; !ok {
+		tokenFiles = append(tokenFiles, legacyChangesTokenFile(taskPath, DefaultRemote))
+	}
+	for _, tokenFile := range tokenFiles {
+		tokenData, err := os.ReadFile(tokenFile)
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			log.Fatalf("Unable to read changes page token: %v", err)
+		}
+		remote := strings.TrimSuffix(path.Base(tokenFile), fmt.Sprintf("-%x.token", sha256.Sum256([]byte(taskPath))))
+		imported = append(imported, tokenFile)
+		if _, err := ReadChangesToken(taskPath, remote); err == nil {
+			continue
+		}
+		ops = append(ops, stateOp{Op: "token", Task: taskPath, Remote: remote, Token: strings.TrimSpace(string(tokenData))})
+	}
+	return ops, imported, staleCopies
+}
+
+//printStaleCopies lists the remote copies orphaned by the migration, they
+//aren't synced, restored or verified anymore.
+func printStaleCopies(staleCopies []staleCopy) {
+	for _, c := range staleCopies {
+		fmt.Printf("Remote copy %v of %q in %v was synced again since the upgrade, it's not tracked anymore\n", c.id, c.source, c.remote)
+	}
+}
+
+//MigrateState imports the sidecars and the changes page tokens written by
+//older versions for the task at taskPath into the sync state, as a single
+//change. States already in the sync state are kept, they were written by a
+//later sync. The imported sidecars are removed when cleanup is set.
+func MigrateState(taskPath string, cleanup bool) {
+	AddStateTask(taskPath)
+	st := loadState()
+	ops, imported, staleCopies := st.legacyState(taskPath, GetRemotes())
+	st.commit(ops...)
+	fmt.Printf("Imported %v sync state files of %q\n", len(imported), taskPath)
+	printStaleCopies(staleCopies)
+
+	if !cleanup {
+		return
+	}
+	for _, file := range imported {
+		if err := os.Remove(file); err != nil && !errors.Is(err, os.ErrNotExist) {
+			log.Fatalf("Unable to remove %q: %v", file, err)
+		}
+	}
+	fmt.Printf("Removed %v sync state files of %q\n", len(imported), taskPath)
+}
+
+//ImportLegacyState imports the sync state files written by older versions
+//for the task at taskPath if it has no sync state yet, so the first sync
+//after an upgrade doesn't upload every file again. In a dry run the state
+//is only imported in memory.
+func ImportLegacyState(taskPath string, dryRun bool) {
+	st := loadState()
+	remotes := GetRemotes()
+	names := []string{DefaultRemote}
+	for name := range remotes {
+		names = append(names, name)
+	}
+	for _, name := range names {
+		if len(st.under(taskPath, name)) > 0 {
+			return
+		}
+	}
+	if task, ok := st.Tasks[taskPath]; ok && len(task.Tokens) > 0 {
+		return
+	}
+
+	if dryRun {
+		for _, op := range st.addTask(taskPath) {
+			st.apply(op)
+		}
+	} else {
+		AddStateTask(taskPath)
+	}
+	//there's no newer state to orphan a remote copy
+	ops, imported, _ := st.legacyState(taskPath, remotes)
+	if len(imported) == 0 {
+		return
+	}
+	if dryRun {
+		for _, op := range ops {
+			st.apply(op)
+		}
+		fmt.Printf("Would import %v sync state files of %q written by an older version\n", len(imported), taskPath)
+	} else {
+		st.commit(ops...)
+		fmt.Printf("Imported %v sync state files of %q written by an older version, remove them with \"dsync migrate-state --cleanup\"\n", len(imported), taskPath)
+	}
+}
+
+//legacyChangesTokenFile returns the file keeping the changes page token of
+//the given task path and remote written by older versions.
+func legacyChangesTokenFile(taskPath, remote string) string {
+	return path.Join(legacyChangesDir, fmt.Sprintf("%s-%x.token", remote, sha256.Sum256([]byte(taskPath))))
+}
+
+// migrateStateCmd represents the migrate-state command
+var migrateStateCmd = &cobra.Command{
+	Use:   "migrate-state [task]",
+	Short: "Import the sync state files written by older versions",
+	Long: `Import the checksum and folder Id files written next to the synced files
+and folders by older versions into the sync state of a task or of all tasks:
+"dsync migrate-state [task] [--cleanup]"
+The first sync of a task without a sync state imports them on its own.
+Nothing is uploaded, the next sync only uploads the files changed since
+their last sync. Files synced again since the upgrade keep their newer
+state, the remote copies of their older state are listed as they aren't
+tracked anymore and can be deleted. With "--cleanup" the imported files are removed once imported.`,
+	Args: cobra.MaximumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		tasks := GetTasks()
+		if len(args) == 1 {
+			taskPath, err := filepath.Abs(args[0])
+			if err != nil {
+				log.Fatalf("Unable to get file or directory %q: %v", args[0], err)
+			}
+			tasks = []Task{FindTask(taskPath)}
+		}
+		cleanup, _ := cmd.Flags().GetBool("cleanup")
+
+		//nested tasks keep the state of their files once added
+		for _, task := range GetTasks() {
+			AddStateTask(task.Path)
+		}
+		for _, task := range tasks {
+			MigrateState(task.Path, cleanup)
+		}
+		if cleanup {
+			//the changes dir is only removed once empty
+			os.Remove(legacyChangesDir)
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(migrateStateCmd)
+
+	migrateStateCmd.Flags().Bool("cleanup", false, "Remove the imported sync state files")
+}
@@ -0,0 +1,113 @@
+/*
+Copyright © 2022 NAME HERE <EMAIL ADDRESS>
+
+*/
+package cmd
+
+import (
+	"os"
+	"path"
+	"testing"
+)
+
+//downgradeState writes the sync state of the files inside root in the
+//default remote to sidecars, the way older versions kept it, and drops the
+//sync state.
+func downgradeState(t *testing.T, root string) {
+	t.Helper()
+	st := loadState()
+	for _, file := range st.under(root, DefaultRemote) {
+		entry, _ := st.get(file, DefaultRemote)
+		dir, name := path.Split(file)
+		if entry.IsDir {
+			writeFile(t, path.Join(dir, "."+name+".dsync"), entry.ID+"\n")
+		} else {
+			writeFile(t, path.Join(dir, "."+name+".sha256sum"), entry.Hash+"\n"+entry.ID+"\n"+entry.Revision)
+		}
+	}
+	resetState()
+	for _, file := range []string{StateFile, StateJournal} {
+		if err := os.Remove(file); err != nil && !os.IsNotExist(err) {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestSyncImportsLegacyState(t *testing.T) {
+	testHome(t)
+	s := startFakeDrive(t)
+	src := path.Join(t.TempDir(), "src")
+	writeFile(t, path.Join(src, "a.txt"), "a")
+	writeFile(t, path.Join(src, "sub", "b.txt"), "b")
+	runDsync(t, "sync", src)
+	_, bId, _, _ := ReadChkSum(path.Join(src, "sub", "b.txt"), DefaultRemote)
+	downgradeState(t, src)
+	files := len(s.Files())
+
+	//a dry run imports the state in memory only
+	runDsync(t, "sync", src, "--dry-run")
+	resetState()
+	if _, err := ReadState(path.Join(src, "a.txt"), DefaultRemote); err == nil {
+		t.Error("dry run wrote the sync state")
+	}
+
+	writeFile(t, path.Join(src, "sub", "b.txt"), "updated")
+	runDsync(t, "sync", src)
+	if len(s.Files()) != files {
+		t.Errorf("first sync after the upgrade created %v remote files", len(s.Files())-files)
+	}
+	checkTree(t, s, map[string]string{"src/": "", "src/a.txt": "a", "src/sub/": "", "src/sub/b.txt": "updated"})
+	for _, file := range []string{src, path.Join(src, "a.txt"), path.Join(src, "sub"), path.Join(src, "sub", "b.txt")} {
+		checkState(t, s, file)
+	}
+	if _, fileId, _, _ := ReadChkSum(path.Join(src, "sub", "b.txt"), DefaultRemote); fileId != bId {
+		t.Errorf("updated file Id = %v, want %v", fileId, bId)
+	}
+}
+
+func TestMigrateStateStaleCopies(t *testing.T) {
+	testHome(t)
+	s := startFakeDrive(t)
+	src := path.Join(t.TempDir(), "src")
+	writeFile(t, path.Join(src, "a.txt"), "a")
+	writeFile(t, path.Join(src, "b.txt"), "b")
+	runDsync(t, "sync", src)
+	_, oldId, _, _ := ReadChkSum(path.Join(src, "a.txt"), DefaultRemote)
+	downgradeState(t, src)
+	//a.txt is synced on its own before the sidecars are imported
+	aSidecar := path.Join(src, ".a.txt.sha256sum")
+	sidecarData, err := os.ReadFile(aSidecar)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Remove(aSidecar); err != nil {
+		t.Fatal(err)
+	}
+	runDsync(t, "sync", path.Join(src, "a.txt"))
+	writeFile(t, aSidecar, string(sidecarData))
+	_, aId, _, _ := ReadChkSum(path.Join(src, "a.txt"), DefaultRemote)
+
+	st := loadState()
+	ops, imported, staleCopies := st.legacyState(src, GetRemotes())
+	if len(imported) != 3 {
+		t.Errorf("imported %v, want the sidecars of src, a.txt and b.txt", imported)
+	}
+	want := staleCopy{source: path.Join(src, "a.txt"), remote: DefaultRemote, id: oldId}
+	if len(staleCopies) != 1 || staleCopies[0] != want {
+		t.Errorf("stale copies = %+v, want [%+v]", staleCopies, want)
+	}
+	for _, op := range ops {
+		if op.Op == "put" && path.Join(op.Task, op.Path) == path.Join(src, "a.txt") {
+			t.Errorf("the sidecar replaces the newer state of a.txt: %+v", op)
+		}
+	}
+
+	runDsync(t, "migrate-state", src, "--cleanup")
+	if _, fileId, _, _ := ReadChkSum(path.Join(src, "a.txt"), DefaultRemote); fileId != aId {
+		t.Errorf("a.txt Id = %v after the migration, want %v", fileId, aId)
+	}
+	checkState(t, s, path.Join(src, "b.txt"))
+	if _, err := os.Stat(aSidecar); !os.IsNotExist(err) {
+		t.Errorf("sidecar kept by --cleanup: %v", err)
+	}
+}
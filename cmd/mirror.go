@@ -0,0 +1,92 @@
+/*
+Copyright © 2022 NAME HERE <EMAIL ADDRESS>
+
+*/
+package cmd
+
+import (
+	"fmt"
+	"log"
+	"path"
+	"strings"
+)
+
+//Mirror policies applied to remote files and folders whose local source
+//is gone, tasks without a mirror policy never remove remote files.
+const (
+	MirrorTrash  = "trash"
+	MirrorDelete = "delete"
+)
+
+//Trasher is implemented by backends able to move files and folders to a
+//trash bin instead of deleting them.
+type Trasher interface {
+	Trash(id string) error
+}
+
+//pendingMirror is a synced folder waiting to be mirrored.
+type pendingMirror struct {
+	dir      string
+	folderId string
+	keep     map[string]bool
+}
+
+//ValidMirror checks the given mirror policy is supported.
+func ValidMirror(policy string) bool {
+	return policy == "" || policy == MirrorTrash || policy == MirrorDelete
+}
+
+//mirrorDir removes every remote file or folder inside folderId but the ones
+//in keep, the ones synced from outside the task and the ignored ones, then
+//removes the sync state of local files that are gone.
+func (s *Syncer) mirrorDir(dir, folderId string, keep, elsewhere map[string]bool) {
+	remoteFiles, err := s.Backend.List(folderId)
+	if err != nil {
+		log.Fatalf("Unable to list remote folder %q: %v", dir, err)
+	}
+	for _, remoteFile := range remoteFiles {
+		file := path.Join(dir, remoteFile.Name)
+		if keep[remoteFile.ID] || elsewhere[remoteFile.ID] || s.ignore.Ignored(file, remoteFile.IsDir) {
+			continue
+		}
+		s.removeRemote(file, remoteFile)
+	}
+	cleanState(dir, s.Remote)
+}
+
+//syncedElsewhere returns the remote Ids of the files and folders synced to
+//the syncer remote from outside root, other tasks can be stored in the
+//remote folder of root.
+func (s *Syncer) syncedElsewhere(root string) map[string]bool {
+	ids := make(map[string]bool)
+	for taskPath, task := range loadState().Tasks {
+		for rel, entry := range task.Files[s.Remote] {
+			source := path.Join(taskPath, rel)
+			if source != root && !strings.HasPrefix(source, root+"/") {
+				ids[entry.ID] = true
+			}
+		}
+	}
+	return ids
+}
+
+//removeRemote trashes or deletes a remote file following the mirror policy.
+func (s *Syncer) removeRemote(file string, remoteFile *RemoteFile) {
+	if s.Mirror == MirrorTrash {
+		trasher, ok := s.Backend.(Trasher)
+		if !ok {
+			fmt.Printf("Remote %v has no trash, %q was kept, use the %q mirror policy to delete it\n", s.Remote, file, MirrorDelete)
+			return
+		}
+		if err := trasher.Trash(remoteFile.ID); err != nil {
+			log.Fatalf("Unable to trash %q in %v: %v", file, s.Remote, err)
+		}
+		fmt.Printf("Trashed %q Id %v in %v\n", file, remoteFile.ID, s.Remote)
+		return
+	}
+	if err := s.Backend.Delete(remoteFile.ID); err != nil {
+		log.Fatalf("Unable to delete %q in %v: %v", file, s.Remote, err)
+	}
+	fmt.Printf("Deleted %q Id %v in %v\n", file, remoteFile.ID, s.Remote)
+}
+
@@ -0,0 +1,65 @@
+/*
+Copyright © 2022 NAME HERE <EMAIL ADDRESS>
+
+*/
+package cmd
+
+import (
+	"os"
+	"path"
+	"testing"
+)
+
+func TestMirror(t *testing.T) {
+	testHome(t)
+	s := startFakeDrive(t)
+	dir := t.TempDir()
+	src := path.Join(dir, "src")
+	writeFile(t, path.Join(src, "a.txt"), "a")
+	writeFile(t, path.Join(src, "gone.txt"), "gone")
+	writeFile(t, path.Join(src, "sub", "b.txt"), "b")
+	writeFile(t, path.Join(src, "skipped.log"), "log")
+	//another task stored in the remote folder of the first one
+	other := path.Join(dir, "other.txt")
+	writeFile(t, other, "other")
+	runDsync(t, "add", src, "--mirror", MirrorTrash)
+	runDsync(t, "add", other, "--remote", "src")
+	runDsync(t, "all")
+	_, goneId, _, _ := ReadChkSum(path.Join(src, "gone.txt"), DefaultRemote)
+	subId, _ := ReadFolderId(path.Join(src, "sub"), DefaultRemote)
+
+	//the log file is ignored from now on and its remote copy is kept
+	writeFile(t, path.Join(src, ".dsyncignore"), "*.log\n")
+	for _, file := range []string{"gone.txt", "sub"} {
+		if err := os.RemoveAll(path.Join(src, file)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	runDsync(t, "all")
+	checkTree(t, s, map[string]string{
+		"src/": "", "src/a.txt": "a", "src/skipped.log": "log", "src/other.txt": "other",
+	})
+	for _, id := range []string{goneId, subId} {
+		if f, ok := s.Lookup(id); !ok || !f.Trashed {
+			t.Errorf("remote copy %v of a removed file = %+v, %v; want it trashed", id, f, ok)
+		}
+	}
+	for _, file := range []string{path.Join(src, "gone.txt"), path.Join(src, "sub"), path.Join(src, "sub", "b.txt")} {
+		if _, err := ReadState(file, DefaultRemote); err == nil {
+			t.Errorf("sync state of removed %q kept", file)
+		}
+	}
+
+	//deleted for good with the delete policy
+	if err := os.Remove(path.Join(src, "a.txt")); err != nil {
+		t.Fatal(err)
+	}
+	_, aId, _, _ := ReadChkSum(path.Join(src, "a.txt"), DefaultRemote)
+	runDsync(t, "sync", src, "--mirror", MirrorDelete)
+	if _, ok := s.Lookup(aId); ok {
+		t.Error("remote copy of a removed file wasn't deleted")
+	}
+	checkTree(t, s, map[string]string{
+		"src/": "", "src/skipped.log": "log", "src/other.txt": "other",
+	})
+}
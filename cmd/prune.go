@@ -0,0 +1,295 @@
+/*
+Copyright © 2022 NAME HERE <EMAIL ADDRESS>
+
+*/
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+//Retention is the grandfather-father-son policy of the snapshots and the
+//file revisions of a task: the newest copy of each of the last Hourly
+//hours, Daily days, Weekly weeks and Monthly months is kept. The latest
+//copy and the pinned revisions are always kept.
+type Retention struct {
+	Hourly  int `json:"hourly,omitempty"`
+	Daily   int `json:"daily,omitempty"`
+	Weekly  int `json:"weekly,omitempty"`
+	Monthly int `json:"monthly,omitempty"`
+}
+
+//IsZero checks no copy count is set, nothing is pruned then.
+func (r Retention) IsZero() bool {
+	return r.Hourly == 0 && r.Daily == 0 && r.Weekly == 0 && r.Monthly == 0
+}
+
+//String returns the copy counts of the policy.
+func (r Retention) String() string {
+	var counts []string
+	for _, count := range []struct {
+		n      int
+		period string
+	}{{r.Hourly, "hourly"}, {r.Daily, "daily"}, {r.Weekly, "weekly"}, {r.Monthly, "monthly"}} {
+		if count.n > 0 {
+			counts = append(counts, fmt.Sprintf("%v %v", count.n, count.period))
+		}
+	}
+	return strings.Join(counts, ", ")
+}
+
+//Keep returns which of the given times, oldest first, the policy keeps.
+func (r Retention) Keep(times []time.Time) []bool {
+	keep := make([]bool, len(times))
+	if len(times) == 0 {
+		return keep
+	}
+	keep[len(times)-1] = true
+	for _, rule := range []struct {
+		n      int
+		period func(t time.Time) string
+	}{
+		{r.Hourly, func(t time.Time) string { return t.Local().Format("2006-01-02T15") }},
+		{r.Daily, func(t time.Time) string { return t.Local().Format("2006-01-02") }},
+		{r.Weekly, func(t time.Time) string {
+			year, week := t.Local().ISOWeek()
+			return fmt.Sprintf("%d-W%02d", year, week)
+		}},
+		{r.Monthly, func(t time.Time) string { return t.Local().Format("2006-01") }},
+	} {
+		//the newest copy of every period is kept, newest periods first
+		periods := make(map[string]bool)
+		for i := len(times) - 1; i >= 0 && len(periods) < rule.n; i-- {
+			if period := rule.period(times[i]); !periods[period] {
+				periods[period] = true
+				keep[i] = true
+			}
+		}
+	}
+	return keep
+}
+
+//Pruner deletes the snapshots and the file revisions of a task its
+//retention policy doesn't keep.
+type Pruner struct {
+	Remote    string
+	Backend   Backend
+	Retention Retention
+	//Root is the Id of the remote folder the task is synced under, see
+	//ResolveRemotePath.
+	Root string
+	//DryRun prints what would be deleted without deleting it.
+	DryRun bool
+
+	snapshots int
+	revisions int
+}
+
+//PruneSnapshots deletes the snapshots of the task at taskPath the policy
+//doesn't keep. Files uploaded by a deleted snapshot are kept while a kept
+//snapshot references them.
+func (p *Pruner) PruneSnapshots(taskPath string) {
+	rootFolder, err := snapshotRoot(p.Backend, p.Root, taskPath)
+	if err != nil {
+		log.Fatalf("Unable to find the snapshots of %q in %v: %v", taskPath, p.Remote, err)
+	}
+	if rootFolder == nil {
+		return
+	}
+	manifests, err := listSnapshots(p.Backend, rootFolder.ID)
+	if err != nil {
+		log.Fatalf("Unable to list the snapshots of %q in %v: %v", taskPath, p.Remote, err)
+	}
+	times := make([]time.Time, len(manifests))
+	for i, manifestFile := range manifests {
+		times[i], _ = snapshotTime(snapshotName(manifestFile))
+	}
+	keep := p.Retention.Keep(times)
+
+	//remote files referenced by the kept snapshots
+	referenced := make(map[string]bool)
+	for i, manifestFile := range manifests {
+		if !keep[i] {
+			continue
+		}
+		manifest, err := readManifest(p.Backend, manifestFile)
+		if err != nil {
+			log.Fatalf("Unable to read snapshot %v: %v", snapshotName(manifestFile), err)
+		}
+		for _, entry := range manifest.Files {
+			referenced[entry.ID] = true
+		}
+	}
+	folders, err := p.Backend.List(rootFolder.ID)
+	if err != nil {
+		log.Fatalf("Unable to list the snapshots of %q in %v: %v", taskPath, p.Remote, err)
+	}
+	for i, manifestFile := range manifests {
+		if keep[i] {
+			continue
+		}
+		name := snapshotName(manifestFile)
+		if p.DryRun {
+			fmt.Printf("Would delete snapshot %v of %q in %v\n", name, taskPath, p.Remote)
+			p.snapshots++
+			continue
+		}
+		manifest, err := readManifest(p.Backend, manifestFile)
+		if err != nil {
+			log.Fatalf("Unable to read snapshot %v: %v", name, err)
+		}
+		//files uploaded by the snapshot that kept snapshots don't reference
+		var unreferenced []string
+		shared := false
+		for _, entry := range manifest.Files {
+			if entry.ID == "" || entry.Snapshot != name {
+				continue
+			}
+			if referenced[entry.ID] {
+				shared = true
+			} else {
+				unreferenced = append(unreferenced, entry.ID)
+			}
+		}
+		if shared {
+			for _, id := range unreferenced {
+				if err := p.Backend.Delete(id); err != nil {
+					log.Fatalf("Unable to delete a file of snapshot %v in %v: %v", name, p.Remote, err)
+				}
+			}
+		} else {
+			for _, folder := range folders {
+				if folder.IsDir && folder.Name == name {
+					if err := p.Backend.Delete(folder.ID); err != nil {
+						log.Fatalf("Unable to delete snapshot %v in %v: %v", name, p.Remote, err)
+					}
+				}
+			}
+		}
+		if err := p.Backend.Delete(manifestFile.ID); err != nil {
+			log.Fatalf("Unable to delete snapshot %v in %v: %v", name, p.Remote, err)
+		}
+		fmt.Printf("Deleted snapshot %v of %q in %v\n", name, taskPath, p.Remote)
+		p.snapshots++
+	}
+}
+
+//PruneRevisions deletes the revisions of the synced files of the task at
+//taskPath the policy doesn't keep, backends have to implement Versioner.
+func (p *Pruner) PruneRevisions(taskPath string, ignore *Ignorer) {
+	versioner, ok := p.Backend.(Versioner)
+	if !ok {
+		return
+	}
+	pruneFile := func(file string) {
+		_, fileId, _, err := ReadChkSum(file, p.Remote)
+		if err != nil {
+			return
+		}
+		revisions, err := versioner.Revisions(fileId)
+		if err != nil {
+			log.Fatalf("Unable to list the revisions of %q: %v", file, err)
+		}
+		times := make([]time.Time, len(revisions))
+		for i, revision := range revisions {
+			times[i] = revision.ModTime
+		}
+		keep := p.Retention.Keep(times)
+		for i, revision := range revisions {
+			if keep[i] || revision.KeepForever {
+				continue
+			}
+			when := revision.ModTime.Local().Format("2006-01-02 15:04:05")
+			if p.DryRun {
+				fmt.Printf("Would delete revision %v of %q of %v\n", revision.ID, file, when)
+				p.revisions++
+				continue
+			}
+			if err := versioner.DeleteRevision(fileId, revision.ID); err != nil {
+				log.Fatalf("Unable to delete revision %v of %q: %v", revision.ID, file, err)
+			}
+			fmt.Printf("Deleted revision %v of %q of %v\n", revision.ID, file, when)
+			p.revisions++
+		}
+	}
+	if _, err := ReadFolderId(taskPath, p.Remote); err != nil {
+		pruneFile(taskPath)
+		return
+	}
+	walkState(taskPath, p.Remote, ignore, func(source string, isFolder bool) {
+		if !isFolder {
+			pruneFile(source)
+		}
+	})
+}
+
+// pruneCmd represents the prune command
+var pruneCmd = &cobra.Command{
+	Use:   "prune [task]",
+	Short: "Delete the snapshots and revisions the retention policy doesn't keep",
+	Long: `Delete the snapshots and the file revisions of a task or of all tasks
+that their retention policy doesn't keep:
+"dsync prune [task] [--dry-run]"
+The policy is set with "dsync add [task] --keep-hourly n --keep-daily n
+--keep-weekly n --keep-monthly n", the newest copy of each of the last n
+hours, days, weeks and months is kept. The latest copy and the pinned
+revisions are never deleted, tasks without a policy aren't pruned.
+With "--dry-run" the snapshots and revisions that would be deleted are
+printed, nothing is deleted.`,
+	Args: cobra.MaximumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		tasks := GetTasks()
+		if len(args) == 1 {
+			taskPath, err := filepath.Abs(args[0])
+			if err != nil {
+				log.Fatalf("Unable to get file or directory %q: %v", args[0], err)
+			}
+			tasks = []Task{FindTask(taskPath)}
+		}
+		dryRun, _ := cmd.Flags().GetBool("dry-run")
+
+		backends := make(map[string]Backend)
+		for _, task := range tasks {
+			if task.Retention == nil || task.Retention.IsZero() {
+				fmt.Printf("Task %q has no retention policy and wasn't pruned\n", task.Path)
+				continue
+			}
+			for _, remoteName := range task.Remotes() {
+				backend := TaskBackend(task, remoteName, backends)
+				root, err := ResolveRemotePath(backend, task.RemotePath, false)
+				if errors.Is(err, os.ErrNotExist) {
+					fmt.Printf("Task %q isn't synced to %v yet\n", task.Path, remoteName)
+					continue
+				}
+				if err != nil {
+					log.Fatalf("Unable to get remote folder %q in %v: %v", task.RemotePath, remoteName, err)
+				}
+				pruner := &Pruner{Remote: remoteName, Backend: backend, Retention: *task.Retention, Root: root, DryRun: dryRun}
+				if task.Snapshot {
+					pruner.PruneSnapshots(task.Path)
+				} else {
+					pruner.PruneRevisions(task.Path, NewIgnorer(task.Path, task.Exclude, task.Include))
+				}
+				verb := "Deleted"
+				if dryRun {
+					verb = "Would delete"
+				}
+				fmt.Printf("%v %v snapshots and %v revisions of %q in %v\n", verb, pruner.snapshots, pruner.revisions, task.Path, remoteName)
+			}
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(pruneCmd)
+
+	pruneCmd.Flags().BoolP("dry-run", "n", false, "Print what would be deleted without deleting it")
+}
@@ -0,0 +1,112 @@
+/*
+Copyright © 2022 NAME HERE <EMAIL ADDRESS>
+
+*/
+package cmd
+
+import (
+	"os"
+	"path"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestRetentionKeep(t *testing.T) {
+	at := func(month time.Month, day, hour, minute int) time.Time {
+		return time.Date(2022, month, day, hour, minute, 0, 0, time.Local)
+	}
+	for _, test := range []struct {
+		name      string
+		retention Retention
+		times     []time.Time
+		want      []bool
+	}{
+		{"none", Retention{}, nil, []bool{}},
+		{"latest only", Retention{}, []time.Time{at(1, 1, 0, 0), at(1, 2, 0, 0)}, []bool{false, true}},
+		{
+			"hourly",
+			Retention{Hourly: 2},
+			[]time.Time{at(1, 2, 9, 30), at(1, 2, 10, 0), at(1, 2, 10, 59), at(1, 2, 11, 0)},
+			[]bool{false, false, true, true},
+		},
+		{
+			"daily across midnight",
+			Retention{Daily: 2},
+			[]time.Time{at(1, 30, 10, 0), at(1, 30, 22, 0), at(1, 31, 9, 0), at(1, 31, 23, 59), at(2, 1, 0, 0)},
+			[]bool{false, false, false, true, true},
+		},
+		{
+			//2022-01-02 is a Sunday of the last ISO week of 2021
+			"weekly across Monday",
+			Retention{Weekly: 3},
+			[]time.Time{at(1, 1, 12, 0), at(1, 2, 23, 59), at(1, 3, 0, 0), at(1, 9, 23, 0), at(1, 10, 0, 0)},
+			[]bool{false, true, false, true, true},
+		},
+		{
+			"monthly across month ends",
+			Retention{Monthly: 3},
+			[]time.Time{at(1, 15, 0, 0), at(1, 31, 23, 59), at(2, 1, 0, 0), at(2, 28, 12, 0), at(3, 1, 0, 0)},
+			[]bool{false, true, false, true, true},
+		},
+		{
+			"hourly and daily",
+			Retention{Hourly: 2, Daily: 2},
+			[]time.Time{at(1, 1, 8, 0), at(1, 1, 9, 30), at(1, 2, 10, 0), at(1, 2, 10, 59), at(1, 2, 11, 0)},
+			[]bool{false, true, false, true, true},
+		},
+	} {
+		if keep := test.retention.Keep(test.times); !reflect.DeepEqual(keep, test.want) {
+			t.Errorf("%v: Keep = %v, want %v", test.name, keep, test.want)
+		}
+	}
+}
+
+func TestPruneSnapshotsKeepsReferencedFiles(t *testing.T) {
+	testHome(t)
+	remoteDir := t.TempDir()
+	backend := NewLocalBackend(remoteDir)
+	src := path.Join(t.TempDir(), "src")
+	writeFile(t, path.Join(src, "a.txt"), "a")
+	writeFile(t, path.Join(src, "x.txt"), "x")
+	(&Syncer{Remote: "local", Backend: backend, Snapshot: true}).Sync(src)
+	manifests, err := listSnapshots(backend, "src"+SnapshotSuffix)
+	if err != nil || len(manifests) != 1 {
+		t.Fatalf("snapshots = %v, %v; want 1", manifests, err)
+	}
+	first := snapshotName(manifests[0])
+
+	//the next snapshot references a.txt of the first one
+	if err := os.Remove(path.Join(src, "x.txt")); err != nil {
+		t.Fatal(err)
+	}
+	writeFile(t, path.Join(src, "b.txt"), "b")
+	time.Sleep(time.Until(time.Now().Truncate(time.Second).Add(time.Second)))
+	(&Syncer{Remote: "local", Backend: backend, Snapshot: true}).Sync(src)
+
+	pruner := &Pruner{Remote: "local", Backend: backend, Retention: Retention{Hourly: 1}}
+	pruner.PruneSnapshots(src)
+	if pruner.snapshots != 1 {
+		t.Errorf("pruned %v snapshots, want 1", pruner.snapshots)
+	}
+	snapshots := path.Join(remoteDir, "src"+SnapshotSuffix)
+	if _, err := os.Stat(path.Join(snapshots, first+".json")); !os.IsNotExist(err) {
+		t.Errorf("manifest of the pruned snapshot kept: %v", err)
+	}
+	if _, err := os.Stat(path.Join(snapshots, first, "src", "x.txt")); !os.IsNotExist(err) {
+		t.Errorf("file only the pruned snapshot references kept: %v", err)
+	}
+
+	//the kept snapshot restores the file uploaded by the pruned one
+	to := t.TempDir()
+	restorer := &Restorer{Remote: "local", Backend: backend}
+	restorer.RestoreSnapshot(LoadSnapshot(backend, "local", Task{Path: src}, ""), src, path.Join(to, "src"))
+	for name, content := range map[string]string{"a.txt": "a", "b.txt": "b"} {
+		if data, err := os.ReadFile(path.Join(to, "src", name)); err != nil || string(data) != content {
+			t.Errorf("restored %q = %q, %v; want %q", name, data, err, content)
+		}
+	}
+	if restorer.failed != 0 {
+		t.Errorf("%v files failed the checksum verification", restorer.failed)
+	}
+}
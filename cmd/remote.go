@@ -0,0 +1,328 @@
+/*
+Copyright © 2022 NAME HERE <EMAIL ADDRESS>
+
+*/
+package cmd
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+
+	"github.com/spf13/cobra"
+)
+
+//remotes file used to store all user configured remotes
+var RemotesFile = path.Join(UserHome, ".dsync/remotes.json")
+
+//DefaultRemote is the remote used by tasks without a destination,
+//it doesn't need to be configured and targets the user Google Drive.
+const DefaultRemote = "drive"
+
+//Remote types supported by dsync.
+const (
+	RemoteDrive  = "drive"
+	RemoteLocal  = "local"
+	RemoteWebDAV = "webdav"
+	RemoteS3     = "s3"
+	RemoteSFTP   = "sftp"
+)
+
+//Remote is a named destination tasks can be synced to.
+type Remote struct {
+	Name string `json:"name"`
+	Type string `json:"type"`
+	//Path is the target directory of local and SFTP remotes.
+	Path string `json:"path,omitempty"`
+	//URL is the collection URL of WebDAV remotes.
+	URL string `json:"url,omitempty"`
+	//User is the login of WebDAV and SFTP remotes.
+	User     string `json:"user,omitempty"`
+	Password string `json:"password,omitempty"`
+	//Endpoint, Region, Bucket and Prefix locate the objects of S3 remotes,
+	//Endpoint and NoAuth point Drive remotes to a fake Drive server.
+	Endpoint  string `json:"endpoint,omitempty"`
+	NoAuth    bool   `json:"no_auth,omitempty"`
+	Region    string `json:"region,omitempty"`
+	Bucket    string `json:"bucket,omitempty"`
+	Prefix    string `json:"prefix,omitempty"`
+	AccessKey string `json:"access_key,omitempty"`
+	SecretKey string `json:"secret_key,omitempty"`
+	//Host, KeyFile and KnownHosts configure the connection to SFTP remotes.
+	Host       string `json:"host,omitempty"`
+	KeyFile    string `json:"key_file,omitempty"`
+	KnownHosts string `json:"known_hosts,omitempty"`
+}
+
+//Location returns where the remote stores the synced files.
+func (r *Remote) Location() string {
+	switch r.Type {
+	case RemoteDrive:
+		return r.Endpoint
+	case RemoteLocal:
+		return r.Path
+	case RemoteWebDAV:
+		return r.URL
+	case RemoteS3:
+		return fmt.Sprintf("%s/%s/%s", r.Endpoint, r.Bucket, r.Prefix)
+	case RemoteSFTP:
+		return fmt.Sprintf("%s@%s:%s", r.User, r.Host, r.Path)
+	}
+	return ""
+}
+
+//GetRemotes returns all remotes configured by the user.
+func GetRemotes() map[string]*Remote {
+	remotes := make(map[string]*Remote)
+	data, err := os.ReadFile(RemotesFile)
+	if errors.Is(err, os.ErrNotExist) {
+		return remotes
+	}
+	if err != nil {
+		log.Fatalf("Unable to read file %q: %v", RemotesFile, err)
+	}
+	if err := json.Unmarshal(data, &remotes); err != nil {
+		log.Fatalf("Unable to parse file %q: %v", RemotesFile, err)
+	}
+	for name, remote := range remotes {
+		remote.Name = name
+	}
+	return remotes
+}
+
+//SaveRemotes writes the given remotes to the remotes file.
+func SaveRemotes(remotes map[string]*Remote) {
+	if err := os.Mkdir(path.Dir(RemotesFile), 0750); err != nil && !os.IsExist(err) {
+		log.Fatalf("Could'n create '.dsync' folder: %v", err)
+	}
+	data, err := json.MarshalIndent(remotes, "", "  ")
+	if err != nil {
+		log.Fatalf("Unable to encode remotes: %v", err)
+	}
+	if err := os.WriteFile(RemotesFile, data, 0600); err != nil {
+		log.Fatalf("Unable to update remotes file: %v", err)
+	}
+}
+
+//GetRemote returns the remote with the given name, an empty name stands for
+//the default remote.
+func GetRemote(name string) *Remote {
+	if name == "" {
+		name = DefaultRemote
+	}
+	if remote, ok := GetRemotes()[name]; ok {
+		return remote
+	}
+	if name == DefaultRemote {
+		return &Remote{Name: DefaultRemote, Type: RemoteDrive}
+	}
+	log.Fatalf("Unknown remote %q, add it with \"dsync remote add\"", name)
+	return nil
+}
+
+//GetBackend returns the Backend for the given remote, drive remotes are
+//authorized to use the whole drive when fullAccess is set.
+func GetBackend(remote *Remote, fullAccess bool) Backend {
+	switch remote.Type {
+	case RemoteDrive:
+		return NewDriveBackend(GetDriveService(remote, fullAccess), fullAccess)
+	case RemoteLocal:
+		if err := os.MkdirAll(remote.Path, 0750); err != nil {
+			log.Fatalf("Unable to use remote %q directory %q: %v", remote.Name, remote.Path, err)
+		}
+		return NewLocalBackend(remote.Path)
+	case RemoteWebDAV:
+		backend, err := NewWebDAVBackend(remote.URL, remote.User, remote.Password)
+		if err != nil {
+			log.Fatalf("Unable to use remote %q URL %q: %v", remote.Name, remote.URL, err)
+		}
+		return backend
+	case RemoteS3:
+		backend, err := NewS3Backend(remote.Endpoint, remote.Region, remote.Bucket, remote.Prefix, remote.AccessKey, remote.SecretKey)
+		if err != nil {
+			log.Fatalf("Unable to use remote %q endpoint %q: %v", remote.Name, remote.Endpoint, err)
+		}
+		return backend
+	case RemoteSFTP:
+		backend, err := NewSFTPBackend(remote.Host, remote.User, remote.KeyFile, remote.KnownHosts, remote.Path)
+		if err != nil {
+			log.Fatalf("Unable to connect to remote %q host %q: %v", remote.Name, remote.Host, err)
+		}
+		return backend
+	}
+	log.Fatalf("Unknown type %q of remote %q", remote.Type, remote.Name)
+	return nil
+}
+
+// remoteCmd represents the remote command
+var remoteCmd = &cobra.Command{
+	Use:   "remote",
+	Short: "Manage the remotes tasks can be synced to",
+	Long: `Manage the remotes tasks can be synced to:
+"dsync remote add [name] --type drive [--endpoint url --no-auth]"
+"dsync remote add [name] --type local --path [dir]"
+"dsync remote add [name] --type webdav --url [url] --user [user] --password [password]"
+"dsync remote add [name] --type s3 --endpoint [url] --bucket [bucket] --access-key [key] --secret-key [secret]"
+"dsync remote add [name] --type sftp --host [host[:port]] --user [user] --path [dir] --key-file [private key]"
+"dsync remote list"
+"dsync remote remove [name]"
+Tasks without a destination are synced to the "drive" remote,
+the user Google Drive.`,
+}
+
+// remoteAddCmd represents the remote add command
+var remoteAddCmd = &cobra.Command{
+	Use:   "add [name]",
+	Short: "Add or replace a remote",
+	Long: `Add or replace a remote:
+"dsync remote add [name] --type local --path [dir]"
+"dsync remote add [name] --type webdav --url [url] --user [user] --password [password]"
+"dsync remote add [name] --type s3 --endpoint [url] --bucket [bucket] --access-key [key] --secret-key [secret]
+    [--region region] [--prefix prefix]"
+"dsync remote add [name] --type sftp --host [host[:port]] --user [user] --path [dir] --key-file [private key]
+    [--known-hosts file]"
+Drive remotes upload the tasks to the user Google Drive, a fake Drive server
+can be used instead with the "--endpoint" and "--no-auth" flags while testing.
+Drive remotes are only authorized to use the files dsync created, tasks
+stored in a shared drive or synced both ways ask to authorize dsync again
+for the whole drive on their first sync, see "dsync authorize".
+Local remotes copy the tasks to a local or mounted (NAS) directory,
+WebDAV remotes upload them to a WebDAV collection (Nextcloud, ownCloud...),
+S3 remotes upload them to a S3 compatible bucket (AWS, MinIO...)
+and SFTP remotes upload them to a directory of a SSH server.
+SFTP remotes use the keys of the running ssh-agent if no key file is given.`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		remote := &Remote{Name: args[0]}
+		remote.Type, _ = cmd.Flags().GetString("type")
+		switch remote.Type {
+		case RemoteDrive:
+			remote.Endpoint, _ = cmd.Flags().GetString("endpoint")
+			remote.NoAuth, _ = cmd.Flags().GetBool("no-auth")
+		case RemoteLocal:
+			dir, _ := cmd.Flags().GetString("path")
+			if dir == "" {
+				log.Fatalln("Error, missing '--path' flag for a local remote")
+			}
+			absDir, err := filepath.Abs(dir)
+			if err != nil {
+				log.Fatalf("Unable to get directory %q: %v", dir, err)
+			}
+			remote.Path = absDir
+		case RemoteWebDAV:
+			remote.URL, _ = cmd.Flags().GetString("url")
+			if remote.URL == "" {
+				log.Fatalln("Error, missing '--url' flag for a webdav remote")
+			}
+			remote.User, _ = cmd.Flags().GetString("user")
+			remote.Password, _ = cmd.Flags().GetString("password")
+		case RemoteS3:
+			remote.Endpoint, _ = cmd.Flags().GetString("endpoint")
+			remote.Bucket, _ = cmd.Flags().GetString("bucket")
+			if remote.Endpoint == "" || remote.Bucket == "" {
+				log.Fatalln("Error, missing '--endpoint' or '--bucket' flag for a s3 remote")
+			}
+			remote.Region, _ = cmd.Flags().GetString("region")
+			remote.Prefix, _ = cmd.Flags().GetString("prefix")
+			remote.AccessKey, _ = cmd.Flags().GetString("access-key")
+			remote.SecretKey, _ = cmd.Flags().GetString("secret-key")
+		case RemoteSFTP:
+			remote.Host, _ = cmd.Flags().GetString("host")
+			remote.User, _ = cmd.Flags().GetString("user")
+			remote.Path, _ = cmd.Flags().GetString("path")
+			if remote.Host == "" || remote.User == "" || remote.Path == "" {
+				log.Fatalln("Error, missing '--host', '--user' or '--path' flag for a sftp remote")
+			}
+			remote.KeyFile, _ = cmd.Flags().GetString("key-file")
+			if remote.KeyFile != "" {
+				keyFile, err := filepath.Abs(remote.KeyFile)
+				if err != nil {
+					log.Fatalf("Unable to get key file %q: %v", remote.KeyFile, err)
+				}
+				remote.KeyFile = keyFile
+			}
+			remote.KnownHosts, _ = cmd.Flags().GetString("known-hosts")
+		default:
+			log.Fatalf("Unknown remote type %q", remote.Type)
+		}
+		remotes := GetRemotes()
+		remotes[remote.Name] = remote
+		SaveRemotes(remotes)
+	},
+}
+
+// remoteListCmd represents the remote list command
+var remoteListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List all remotes",
+	Long: `List all remotes:
+"dsync remote list".`,
+	Args: cobra.ExactArgs(0),
+	Run: func(cmd *cobra.Command, args []string) {
+		remotes := GetRemotes()
+		if _, ok := remotes[DefaultRemote]; !ok {
+			remotes[DefaultRemote] = GetRemote(DefaultRemote)
+		}
+		var names []string
+		for name := range remotes {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		fmt.Println("Remotes List:")
+		for _, name := range names {
+			fmt.Printf("%v\t%v\t%v\n", name, remotes[name].Type, remotes[name].Location())
+		}
+	},
+}
+
+// remoteRemoveCmd represents the remote remove command
+var remoteRemoveCmd = &cobra.Command{
+	Use:   "remove [name]",
+	Short: "Remove a remote",
+	Long: `Remove a remote:
+"dsync remote remove [name]".`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		remotes := GetRemotes()
+		if _, ok := remotes[args[0]]; !ok {
+			log.Fatalf("Unknown remote %q", args[0])
+		}
+		for _, task := range GetTasks() {
+			for _, remoteName := range task.Remotes() {
+				if remoteName == args[0] {
+					log.Fatalf("Remote %q is used by task %q", args[0], task.Path)
+				}
+			}
+		}
+		delete(remotes, args[0])
+		SaveRemotes(remotes)
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(remoteCmd)
+	remoteCmd.AddCommand(remoteAddCmd)
+	remoteCmd.AddCommand(remoteListCmd)
+	remoteCmd.AddCommand(remoteRemoveCmd)
+
+	remoteAddCmd.Flags().StringP("type", "t", RemoteLocal, "Remote type: drive|local|webdav|s3|sftp")
+	remoteAddCmd.Flags().StringP("path", "p", "", "Target directory of a local or sftp remote")
+	remoteAddCmd.Flags().String("url", "", "Collection URL of a webdav remote")
+	remoteAddCmd.Flags().String("user", "", "User of a webdav or sftp remote")
+	remoteAddCmd.Flags().String("password", "", "Password of a webdav remote")
+	remoteAddCmd.Flags().String("endpoint", "", "Endpoint URL of a s3 remote, e.g. https://s3.amazonaws.com, or of a fake Drive server")
+	remoteAddCmd.Flags().Bool("no-auth", false, "Don't authorize requests of a drive remote, used with fake Drive servers")
+	remoteAddCmd.Flags().String("region", "us-east-1", "Region of a s3 remote")
+	remoteAddCmd.Flags().String("bucket", "", "Bucket of a s3 remote")
+	remoteAddCmd.Flags().String("prefix", "", "Key prefix of a s3 remote")
+	remoteAddCmd.Flags().String("access-key", "", "Access key of a s3 remote")
+	remoteAddCmd.Flags().String("secret-key", "", "Secret key of a s3 remote")
+	remoteAddCmd.Flags().String("host", "", "Host of a sftp remote")
+	remoteAddCmd.Flags().String("key-file", "", "Private key file of a sftp remote")
+	remoteAddCmd.Flags().String("known-hosts", path.Join(UserHome, ".ssh/known_hosts"), "Known hosts file of a sftp remote")
+}
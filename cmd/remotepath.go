@@ -0,0 +1,54 @@
+/*
+Copyright © 2022 NAME HERE <EMAIL ADDRESS>
+
+*/
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"strings"
+)
+
+//CleanRemotePath returns the given remote folder path without leading,
+//trailing or repeated slashes, e.g. "Backups/laptop". An empty path stands
+//for the remote root.
+func CleanRemotePath(remotePath string) (string, error) {
+	var names []string
+	for _, name := range strings.Split(remotePath, "/") {
+		switch name {
+		case "", ".":
+		case "..":
+			return "", fmt.Errorf("remote path %q can't hold \"..\"", remotePath)
+		default:
+			names = append(names, name)
+		}
+	}
+	return path.Join(names...), nil
+}
+
+//ResolveRemotePath returns the Id of the remote folder at the given path,
+//an empty Id for the remote root. Missing folders are created when create
+//is set, otherwise a wrapped os.ErrNotExist is returned.
+func ResolveRemotePath(b Backend, remotePath string, create bool) (string, error) {
+	folderId := ""
+	if remotePath == "" {
+		return folderId, nil
+	}
+	for _, name := range strings.Split(remotePath, "/") {
+		folder, err := findChild(b, folderId, name)
+		if errors.Is(err, os.ErrNotExist) && create {
+			folder, err = b.CreateFolder(&RemoteFile{Name: name, Parent: folderId})
+		}
+		if err != nil {
+			return "", fmt.Errorf("remote folder %q: %w", remotePath, err)
+		}
+		if !folder.IsDir {
+			return "", fmt.Errorf("remote path %q: %q isn't a folder", remotePath, name)
+		}
+		folderId = folder.ID
+	}
+	return folderId, nil
+}
@@ -5,11 +5,8 @@ Copyright © 2022 NAME HERE <EMAIL ADDRESS>
 package cmd
 
 import (
-	"fmt"
 	"log"
-	"os"
 	"path/filepath"
-	"strings"
 
 	"github.com/spf13/cobra"
 )
@@ -26,14 +23,13 @@ var removeCmd = &cobra.Command{
 		if err != nil {
 			log.Fatalf("Unable to get file or directory %q: %v", args[0], err)
 		}
-		listFileData, err := os.ReadFile(TasksFile)
-		if err != nil {
-			log.Fatalf("Unable to read tasks list file: %v", err)
-		}
-		newList := strings.Replace(string(listFileData), fmt.Sprintf("%s\n", fileToRemove), "", -1)
-		if err := os.WriteFile(TasksFile, []byte(newList), 0644); err != nil {
-			log.Fatalf("Unable to update tasks list file: %v", err)
+		var tasks []Task
+		for _, task := range GetTasks() {
+			if task.Path != fileToRemove {
+				tasks = append(tasks, task)
+			}
 		}
+		SaveTasks(tasks)
 	},
 }
 
@@ -0,0 +1,196 @@
+/*
+Copyright © 2022 NAME HERE <EMAIL ADDRESS>
+
+*/
+package cmd
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+//Mover is implemented by backends able to rename and move files and folders
+//without uploading them again.
+type Mover interface {
+	//Move renames the file or folder f.ID to f.Name and moves it inside
+	//f.Parent, the returned file Id may change.
+	Move(f *RemoteFile) (*RemoteFile, error)
+}
+
+//orphan is the sync state of a file whose local source is gone.
+type orphan struct {
+	source string
+	id     string
+}
+
+//scanOrphans indexes the sync state of the syncer remote inside root whose
+//local source is gone, files are indexed by content hash so renamed or
+//moved files can be found.
+func (s *Syncer) scanOrphans(root string) {
+	s.orphanFiles = make(map[string][]orphan)
+	s.orphanFolders = make(map[string]orphan)
+	_, canMove := s.Backend.(Mover)
+	if s.Backend == nil {
+		//dry runs have no backend, S3 remotes are the only ones unable
+		//to move files
+		canMove = GetRemote(s.Remote).Type != RemoteS3
+	}
+	if !canMove {
+		return
+	}
+	walkState(root, s.Remote, s.ignore, func(source string, isFolder bool) {
+		if _, err := os.Lstat(source); !os.IsNotExist(err) {
+			return
+		}
+		if isFolder {
+			if folderId, err := ReadFolderId(source, s.Remote); err == nil {
+				s.orphanFolders[folderId] = orphan{source: source, id: folderId}
+			}
+		} else if hash, fileId, _, err := ReadChkSum(source, s.Remote); err == nil {
+			s.orphanFiles[hash] = append(s.orphanFiles[hash], orphan{source: source, id: fileId})
+		}
+	})
+}
+
+//moveFile looks for a remote file with the same content as the given new
+//file whose local source is gone, then renames and moves it in place of
+//uploading the file again. It returns false if there is no such file.
+func (s *Syncer) moveFile(file, parent string) bool {
+	if len(s.orphanFiles) == 0 {
+		return false
+	}
+	hash := HashFile(file)
+	orphans := s.orphanFiles[hash]
+	if len(orphans) == 0 {
+		return false
+	}
+	moved := orphans[0]
+	s.orphanFiles[hash] = orphans[1:]
+	if s.DryRun {
+		fmt.Printf("Would move file %q to %q in %v\n", moved.source, file, s.Remote)
+		s.plan.moves++
+		return true
+	}
+
+	remoteFile, err := s.Backend.(Mover).Move(&RemoteFile{
+		ID:     moved.id,
+		Name:   filepath.Base(file),
+		Parent: parent,
+	})
+	if err != nil {
+		log.Fatalf("Unable to move file %q to %q in %v: %v", moved.source, file, s.Remote, err)
+	}
+	fmt.Printf("Moved file %q to %q Id %v in %v\n", moved.source, file, remoteFile.ID, s.Remote)
+	RemoveState(moved.source, s.Remote)
+	writeChkSum(file, s.Remote, hash, remoteFile)
+	return true
+}
+
+//moveMatch is the share of files, both of the orphan folder and of the new
+//dir, with the same name and content needed to take the new dir for the
+//orphan folder renamed or moved.
+const moveMatch = 0.8
+
+//moveDir looks for a remote folder whose local source is gone and that held
+//mostly the same files, by name and content, as the given new dir, then
+//renames and moves it in place of creating a new folder. It returns the
+//folder Id or an empty string if there is no such folder.
+func (s *Syncer) moveDir(dir, parent string) string {
+	if len(s.orphanFolders) == 0 {
+		return ""
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		log.Fatalf("Unable to read dir: %v", err)
+	}
+	hashes := make(map[string]string)
+	for _, entry := range entries {
+		if entry.Type().IsRegular() {
+			hashes[entry.Name()] = HashFile(path.Join(dir, entry.Name()))
+		}
+	}
+	var moved orphan
+	best := 0
+	st := loadState()
+	for _, folder := range s.orphanFolders {
+		held, matches := 0, 0
+		for _, file := range st.children(folder.source, s.Remote) {
+			entry, ok := st.get(file, s.Remote)
+			if !ok || entry.IsDir {
+				continue
+			}
+			held++
+			if hash, ok := hashes[path.Base(file)]; ok && hash == entry.Hash {
+				matches++
+			}
+		}
+		if matches == 0 || float64(matches) < moveMatch*float64(held) || float64(matches) < moveMatch*float64(len(hashes)) {
+			continue
+		}
+		if matches > best {
+			moved, best = folder, matches
+		}
+	}
+	if moved.id == "" {
+		return ""
+	}
+	delete(s.orphanFolders, moved.id)
+
+	remoteFolder, err := s.Backend.(Mover).Move(&RemoteFile{
+		ID:     moved.id,
+		Name:   filepath.Base(dir),
+		Parent: parent,
+	})
+	if err != nil {
+		log.Fatalf("Unable to move folder %q to %q in %v: %v", moved.source, dir, s.Remote, err)
+	}
+	fmt.Printf("Moved folder %q to %q Id %v in %v\n", moved.source, dir, remoteFolder.ID, s.Remote)
+	//the files inside the folder moved with it and aren't orphans anymore
+	MoveState(moved.source, dir, s.Remote)
+	CreateFolderId(dir, s.Remote, remoteFolder.ID)
+	for hash, orphans := range s.orphanFiles {
+		var left []orphan
+		for _, o := range orphans {
+			if !strings.HasPrefix(o.source, moved.source+"/") {
+				left = append(left, o)
+			}
+		}
+		s.orphanFiles[hash] = left
+	}
+	for id, folder := range s.orphanFolders {
+		if strings.HasPrefix(folder.source, moved.source+"/") {
+			delete(s.orphanFolders, id)
+		}
+	}
+	if remoteFolder.ID != moved.id {
+		//backends using paths as Ids changed the Id of every file inside
+		s.renameIds(dir, moved.id, remoteFolder.ID)
+	}
+	return remoteFolder.ID
+}
+
+//renameIds replaces the oldPrefix of the remote Ids kept by the syncer
+//remote for every file and folder inside dir with newPrefix.
+func (s *Syncer) renameIds(dir, oldPrefix, newPrefix string) {
+	rename := func(id string) (string, bool) {
+		if id != oldPrefix && !strings.HasPrefix(id, strings.TrimSuffix(oldPrefix, "/")+"/") {
+			return id, false
+		}
+		return newPrefix + strings.TrimPrefix(id, oldPrefix), true
+	}
+	for _, file := range loadState().under(dir, s.Remote) {
+		entry, err := ReadState(file, s.Remote)
+		if err != nil || file == dir {
+			continue
+		}
+		if newId, ok := rename(entry.ID); ok {
+			renamed := *entry
+			renamed.ID = newId
+			WriteState(file, s.Remote, &renamed)
+		}
+	}
+}
@@ -0,0 +1,71 @@
+/*
+Copyright © 2022 NAME HERE <EMAIL ADDRESS>
+
+*/
+package cmd
+
+import (
+	"os"
+	"path"
+	"testing"
+)
+
+func TestSyncMovesRenamedDir(t *testing.T) {
+	testHome(t)
+	s := startFakeDrive(t)
+	src := path.Join(t.TempDir(), "src")
+	writeFile(t, path.Join(src, "old", "a.txt"), "a")
+	writeFile(t, path.Join(src, "old", "b.txt"), "b")
+	writeFile(t, path.Join(src, "old", "c.txt"), "c")
+	writeFile(t, path.Join(src, "old", "d.txt"), "d")
+	writeFile(t, path.Join(src, "old", "e.txt"), "e")
+	runDsync(t, "sync", src)
+	oldId, _ := ReadFolderId(path.Join(src, "old"), DefaultRemote)
+	files := len(s.Files())
+
+	//renamed with one of its five files edited
+	if err := os.Rename(path.Join(src, "old"), path.Join(src, "new")); err != nil {
+		t.Fatal(err)
+	}
+	writeFile(t, path.Join(src, "new", "e.txt"), "edited")
+	runDsync(t, "sync", src)
+	if newId, _ := ReadFolderId(path.Join(src, "new"), DefaultRemote); newId != oldId {
+		t.Errorf("renamed folder Id = %v, want %v", newId, oldId)
+	}
+	if len(s.Files()) != files {
+		t.Errorf("renaming the folder created %v remote files", len(s.Files())-files)
+	}
+	checkTree(t, s, map[string]string{
+		"src/": "", "src/new/": "", "src/new/a.txt": "a", "src/new/b.txt": "b",
+		"src/new/c.txt": "c", "src/new/d.txt": "d", "src/new/e.txt": "edited",
+	})
+	if _, err := ReadState(path.Join(src, "old"), DefaultRemote); err == nil {
+		t.Error("sync state of the old folder kept")
+	}
+}
+
+func TestSyncKeepsDirSharingOneFile(t *testing.T) {
+	testHome(t)
+	s := startFakeDrive(t)
+	src := path.Join(t.TempDir(), "src")
+	writeFile(t, path.Join(src, "old", "a.txt"), "a")
+	writeFile(t, path.Join(src, "old", "b.txt"), "b")
+	writeFile(t, path.Join(src, "old", "c.txt"), "c")
+	runDsync(t, "sync", src)
+	oldId, _ := ReadFolderId(path.Join(src, "old"), DefaultRemote)
+
+	//a new folder holding a copy of a single file of the removed one
+	if err := os.RemoveAll(path.Join(src, "old")); err != nil {
+		t.Fatal(err)
+	}
+	writeFile(t, path.Join(src, "other", "a.txt"), "a")
+	writeFile(t, path.Join(src, "other", "x.txt"), "x")
+	runDsync(t, "sync", src)
+	if newId, _ := ReadFolderId(path.Join(src, "other"), DefaultRemote); newId == oldId {
+		t.Error("folder sharing a single file took the place of the removed one")
+	}
+	checkTree(t, s, map[string]string{
+		"src/": "", "src/old/": "", "src/old/b.txt": "b", "src/old/c.txt": "c",
+		"src/other/": "", "src/other/a.txt": "a", "src/other/x.txt": "x",
+	})
+}
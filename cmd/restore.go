@@ -0,0 +1,373 @@
+/*
+Copyright © 2022 NAME HERE <EMAIL ADDRESS>
+
+*/
+package cmd
+
+import (
+	"crypto/md5"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+//Restorer downloads remote files and folders back to the local disk.
+type Restorer struct {
+	Remote  string
+	Backend Backend
+	//InPlace is set when files are restored to the path they were synced
+	//from, their sync state is then updated.
+	InPlace bool
+	//Force overwrites local files that differ from the remote copy.
+	Force bool
+	//At restores the revisions of the files that were current at that
+	//time instead of their current content, when it's set.
+	At time.Time
+
+	failed int
+}
+
+//FindRemoteFile returns the remote copy of the given file or folder, synced
+//alone or inside the task. The remote Ids of the sync state are used when
+//they are known, otherwise the remote copy is looked up by name from the
+//task remote folder.
+func FindRemoteFile(b Backend, remote string, task Task, file string) (*RemoteFile, error) {
+	if folderId, err := ReadFolderId(file, remote); err == nil {
+		return b.Stat(folderId)
+	}
+	if _, fileId, _, err := ReadChkSum(file, remote); err == nil {
+		return b.Stat(fileId)
+	}
+	if file == task.Path {
+		root, err := ResolveRemotePath(b, task.RemotePath, false)
+		if err != nil {
+			return nil, err
+		}
+		return findChild(b, root, filepath.Base(file))
+	}
+	parent, err := FindRemoteFile(b, remote, task, path.Dir(file))
+	if err != nil {
+		return nil, err
+	}
+	return findChild(b, parent.ID, filepath.Base(file))
+}
+
+//findChild returns the file or folder with the given name inside parent.
+func findChild(b Backend, parent, name string) (*RemoteFile, error) {
+	children, err := b.List(parent)
+	if err != nil {
+		return nil, err
+	}
+	for _, child := range children {
+		if child.Name == name {
+			return child, nil
+		}
+	}
+	return nil, fmt.Errorf("%q not found: %w", name, os.ErrNotExist)
+}
+
+//RestoreDir restores a remote folder recurrently into dir, source is the
+//local path the folder was synced from.
+func (r *Restorer) RestoreDir(folder *RemoteFile, dir, source string) {
+	if err := os.MkdirAll(dir, 0750); err != nil {
+		log.Fatalf("Unable to create dir %q: %v", dir, err)
+	}
+	if r.InPlace {
+		CreateFolderId(dir, r.Remote, folder.ID)
+	}
+	children, err := r.Backend.List(folder.ID)
+	if err != nil {
+		log.Fatalf("Unable to list remote folder %q: %v", source, err)
+	}
+	for _, child := range children {
+		if child.IsDir {
+			r.RestoreDir(child, path.Join(dir, child.Name), path.Join(source, child.Name))
+		} else {
+			r.RestoreFile(child, path.Join(dir, child.Name), path.Join(source, child.Name))
+		}
+	}
+}
+
+//RestoreFile downloads a remote file to file and checks its content against
+//the remote MD5 and the synced hash of source.
+func (r *Restorer) RestoreFile(remoteFile *RemoteFile, file, source string) {
+	if remoteFile.LinkTarget != "" {
+		r.RestoreLink(remoteFile, file)
+		return
+	}
+	if !r.At.IsZero() {
+		r.RestoreRevision(remoteFile, file)
+		return
+	}
+	syncedHash, _, _, err := ReadChkSum(source, r.Remote)
+	if err != nil {
+		syncedHash = ""
+	}
+	if _, err := os.Lstat(file); err == nil {
+		if syncedHash != "" && HashFile(file) == syncedHash {
+			fmt.Printf("File %q is already restored\n", file)
+			return
+		}
+		if !r.Force {
+			fmt.Printf("File %q exists and was kept, use --force to overwrite it\n", file)
+			return
+		}
+	}
+
+	hash, err := saveFile(remoteFile, file, syncedHash, func(w io.Writer) error {
+		return r.Backend.Download(remoteFile.ID, w)
+	})
+	if errors.Is(err, errChecksum) {
+		r.failed++
+		fmt.Printf("File %q doesn't match the remote MD5 checksum and wasn't restored\n", file)
+		return
+	}
+	if errors.Is(err, errSyncedChecksum) {
+		//the remote copy was changed after it was synced
+		r.failed++
+		fmt.Printf("File %q doesn't match the synced sha256 checksum and wasn't restored\n", file)
+		return
+	}
+	if err != nil {
+		log.Fatalf("Unable to download %q from %v: %v", file, r.Remote, err)
+	}
+	if r.InPlace {
+		writeChkSum(file, r.Remote, hash, remoteFile)
+	}
+	fmt.Printf("Restored file %q from %v\n", file, r.Remote)
+}
+
+//RestoreLink recreates a symbolic link stored in the remote at file.
+func (r *Restorer) RestoreLink(remoteLink *RemoteFile, file string) {
+	if target, err := os.Readlink(file); err == nil && target == remoteLink.LinkTarget {
+		fmt.Printf("Symbolic link %q is already restored\n", file)
+		return
+	}
+	if _, err := os.Lstat(file); err == nil && !r.Force {
+		fmt.Printf("File %q exists and was kept, use --force to overwrite it\n", file)
+		return
+	}
+	if err := createLink(file, remoteLink.LinkTarget); err != nil {
+		log.Fatalf("Unable to restore symbolic link %q: %v", file, err)
+	}
+	if r.InPlace {
+		writeChkSum(file, r.Remote, linkHash(remoteLink.LinkTarget), remoteLink)
+	}
+	fmt.Printf("Restored symbolic link %q from %v\n", file, r.Remote)
+}
+
+//RestoreRevision downloads to file the revision of a remote file that was
+//current at r.At. The sync state is kept as is, so the next sync uploads
+//the restored content as the current one.
+func (r *Restorer) RestoreRevision(remoteFile *RemoteFile, file string) {
+	versioner, ok := r.Backend.(Versioner)
+	if !ok {
+		log.Fatalf("Remote %v doesn't keep file revisions", r.Remote)
+	}
+	revision, oldest, err := revisionAt(versioner, remoteFile.ID, r.At)
+	if err != nil {
+		log.Fatalf("Unable to list the revisions of %q: %v", file, err)
+	}
+	at := r.At.Format("2006-01-02 15:04:05")
+	if revision == nil && (oldest == nil || remoteFile.Created.After(r.At)) {
+		fmt.Printf("File %q wasn't synced yet at %v and wasn't restored\n", file, at)
+		return
+	}
+	if revision == nil {
+		//the revisions of that time were pruned
+		fmt.Printf("File %q has no revision left of %v and wasn't restored, the oldest one is %v of %v\n",
+			file, at, oldest.ID, oldest.ModTime.Local().Format("2006-01-02 15:04:05"))
+		return
+	}
+	if fileStats, err := os.Lstat(file); err == nil {
+		if fileStats.Mode().IsRegular() {
+			if _, md5Sum := md5File(file); md5Sum == revision.MD5 {
+				fmt.Printf("File %q is already restored\n", file)
+				return
+			}
+		}
+		if !r.Force {
+			fmt.Printf("File %q exists and was kept, use --force to overwrite it\n", file)
+			return
+		}
+	}
+
+	revisionFile := *remoteFile
+	revisionFile.Size = revision.Size
+	revisionFile.MD5 = revision.MD5
+	revisionFile.ModTime = revision.ModTime
+	_, err = saveFile(&revisionFile, file, "", func(w io.Writer) error {
+		return versioner.DownloadRevision(remoteFile.ID, revision.ID, w)
+	})
+	if errors.Is(err, errChecksum) {
+		r.failed++
+		fmt.Printf("File %q doesn't match the remote MD5 checksum and wasn't restored\n", file)
+		return
+	}
+	if err != nil {
+		log.Fatalf("Unable to download %q from %v: %v", file, r.Remote, err)
+	}
+	fmt.Printf("Restored file %q from %v revision %v of %v\n", file, r.Remote, revision.ID, revision.ModTime.Local().Format("2006-01-02 15:04:05"))
+}
+
+//errChecksum is returned when a downloaded file doesn't match its remote MD5.
+var errChecksum = errors.New("remote MD5 checksum mismatch")
+
+//errSyncedChecksum is returned when a downloaded file doesn't match the
+//sha256 hash it was synced with.
+var errSyncedChecksum = errors.New("synced sha256 checksum mismatch")
+
+//downloadFile downloads a remote file to file through a temporary file, the
+//file is only replaced if its content matches the remote MD5, then the
+//metadata the remote keeps is restored. It returns the sha256 hash of the
+//downloaded content.
+func downloadFile(b Backend, remoteFile *RemoteFile, file string) (string, error) {
+	return saveFile(remoteFile, file, "", func(w io.Writer) error {
+		return b.Download(remoteFile.ID, w)
+	})
+}
+
+//saveFile writes to file the content of remoteFile written by download, like
+//downloadFile. The content is also checked against the sha256Sum hash when
+//it's set.
+func saveFile(remoteFile *RemoteFile, file, sha256Sum string, download func(w io.Writer) error) (string, error) {
+	tmp, err := os.CreateTemp(path.Dir(file), ".dsync-*")
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(tmp.Name())
+	sha256Hash := sha256.New()
+	md5Hash := md5.New()
+	err = download(io.MultiWriter(tmp, sha256Hash, md5Hash))
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return "", err
+	}
+	if remoteFile.MD5 != "" && remoteFile.MD5 != fmt.Sprintf("%x", md5Hash.Sum(nil)) {
+		return "", errChecksum
+	}
+	hash := fmt.Sprintf("%x", sha256Hash.Sum(nil))
+	if sha256Sum != "" && sha256Sum != hash {
+		return "", errSyncedChecksum
+	}
+	if err := os.Chmod(tmp.Name(), 0644); err != nil {
+		return "", err
+	}
+	if err := os.Rename(tmp.Name(), file); err != nil {
+		return "", err
+	}
+	if err := applyMeta(file, remoteFile); err != nil {
+		return "", err
+	}
+	return hash, nil
+}
+
+//FindTask returns the task the given file or folder belongs to, the
+//innermost one for nested tasks, or a task with the file itself if no task
+//holds it.
+func FindTask(file string) Task {
+	found := Task{Path: file}
+	matched := false
+	for _, task := range GetTasks() {
+		if (file == task.Path || strings.HasPrefix(file, task.Path+"/")) && (!matched || len(task.Path) > len(found.Path)) {
+			found, matched = task, true
+		}
+	}
+	return found
+}
+
+// restoreCmd represents the restore command
+var restoreCmd = &cobra.Command{
+	Use:   "restore [task|file|dir]",
+	Short: "Restore a task, a file or a directory from a remote",
+	Long: `Restore a task, a file or a directory from a remote:
+"dsync restore [task|file|dir] [--to dir] [--from remote] [--force] [--at time|--snapshot time]"
+Files are restored to the path they were synced from, or inside the
+"--to" directory. Existing local files are kept unless "--force" is set.
+Every downloaded file is checked against the remote MD5 checksum and the
+sha256 hash it was synced with, files that don't match aren't restored and
+the command fails. Restored files get back their modification time, mode
+and owner when the remote keeps them. With "--at" files are restored as they were at the given time, like
+"2006-01-02 15:04", from the remote file revisions. Tasks taking snapshots
+are restored from their latest snapshot, or with "--snapshot" from the
+last one taken at or before the given time.`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		fileToRestore, err := filepath.Abs(args[0])
+		if err != nil {
+			log.Fatalf("Unable to get file or directory %q: %v", args[0], err)
+		}
+		task := FindTask(fileToRestore)
+		remoteName, _ := cmd.Flags().GetString("from")
+		if remoteName == "" {
+			remoteName = task.Remotes()[0]
+		}
+		restorer := &Restorer{Remote: remoteName, Backend: TaskBackend(task, remoteName, make(map[string]Backend))}
+		restorer.Force, _ = cmd.Flags().GetBool("force")
+		if at, _ := cmd.Flags().GetString("at"); at != "" {
+			if restorer.At, err = ParseTime(at); err != nil {
+				log.Fatalf("Unable to restore %q: %v", fileToRestore, err)
+			}
+			if _, ok := restorer.Backend.(Versioner); !ok {
+				log.Fatalf("Remote %v doesn't keep file revisions", remoteName)
+			}
+		}
+		target := fileToRestore
+		if to, _ := cmd.Flags().GetString("to"); to != "" {
+			if target, err = filepath.Abs(path.Join(to, filepath.Base(fileToRestore))); err != nil {
+				log.Fatalf("Unable to get directory %q: %v", to, err)
+			}
+		}
+		restorer.InPlace = target == fileToRestore
+
+		snapshot, _ := cmd.Flags().GetString("snapshot")
+		if snapshot != "" || task.Snapshot {
+			if !restorer.At.IsZero() {
+				log.Fatalf("Snapshots are restored with --snapshot, not --at")
+			}
+			manifest := LoadSnapshot(restorer.Backend, remoteName, task, snapshot)
+			restorer.RestoreSnapshot(manifest, fileToRestore, target)
+			if restorer.failed > 0 {
+				log.Fatalf("%v files failed the checksum verification and weren't restored", restorer.failed)
+			}
+			return
+		}
+
+		remoteFile, err := FindRemoteFile(restorer.Backend, remoteName, task, fileToRestore)
+		if err != nil {
+			log.Fatalf("Unable to find %q in %v: %v", fileToRestore, remoteName, err)
+		}
+		if remoteFile.IsDir {
+			restorer.RestoreDir(remoteFile, target, fileToRestore)
+		} else {
+			if err := os.MkdirAll(path.Dir(target), 0750); err != nil {
+				log.Fatalf("Unable to create dir %q: %v", path.Dir(target), err)
+			}
+			restorer.RestoreFile(remoteFile, target, fileToRestore)
+		}
+		if restorer.failed > 0 {
+			log.Fatalf("%v files failed the checksum verification and weren't restored", restorer.failed)
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(restoreCmd)
+
+	restoreCmd.Flags().StringP("to", "t", "", "Directory to restore into (default the synced path)")
+	restoreCmd.Flags().StringP("from", "f", "", "Remote to restore from (default the first task remote)")
+	restoreCmd.Flags().Bool("force", false, "Overwrite local files that differ from the remote copy")
+	restoreCmd.Flags().String("at", "", "Restore the files as they were at the given time")
+	restoreCmd.Flags().StringP("snapshot", "s", "", "Restore the last snapshot taken at or before the given time")
+}
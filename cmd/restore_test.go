@@ -0,0 +1,96 @@
+/*
+Copyright © 2022 NAME HERE <EMAIL ADDRESS>
+
+*/
+package cmd
+
+import (
+	"os"
+	"path"
+	"strings"
+	"testing"
+)
+
+func TestRestoreCommand(t *testing.T) {
+	testHome(t)
+	s := startFakeDrive(t)
+	src := path.Join(t.TempDir(), "src")
+	writeFile(t, path.Join(src, "a.txt"), "a")
+	writeFile(t, path.Join(src, "sub", "b.txt"), "b")
+	runDsync(t, "add", src)
+	runDsync(t, "all")
+	files := len(s.Files())
+
+	//into another directory
+	to := t.TempDir()
+	runDsync(t, "restore", src, "--to", to)
+	for name, content := range map[string]string{"a.txt": "a", "sub/b.txt": "b"} {
+		if data, err := os.ReadFile(path.Join(to, "src", name)); err != nil || string(data) != content {
+			t.Errorf("restored %q = %q, %v; want %q", name, data, err, content)
+		}
+	}
+
+	//in place, the restored files are synced already
+	if err := os.Remove(path.Join(src, "sub", "b.txt")); err != nil {
+		t.Fatal(err)
+	}
+	runDsync(t, "restore", path.Join(src, "sub"))
+	if data, err := os.ReadFile(path.Join(src, "sub", "b.txt")); err != nil || string(data) != "b" {
+		t.Errorf("restored \"b.txt\" = %q, %v; want \"b\"", data, err)
+	}
+	checkState(t, s, path.Join(src, "sub", "b.txt"))
+	runDsync(t, "all")
+	if len(s.Files()) != files {
+		t.Errorf("sync after restore created %v remote files", len(s.Files())-files)
+	}
+}
+
+func TestRestoreSyncedChecksumMismatch(t *testing.T) {
+	testHome(t)
+	startFakeDrive(t)
+	src := path.Join(t.TempDir(), "src")
+	file := path.Join(src, "a.txt")
+	writeFile(t, file, "a")
+	runDsync(t, "sync", src)
+	synced, err := ReadState(file, DefaultRemote)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	//changed in the remote after it was synced
+	backend := GetBackend(GetRemote(DefaultRemote), false)
+	remoteFile, err := backend.Update(&RemoteFile{ID: synced.ID}, strings.NewReader("remote"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Remove(file); err != nil {
+		t.Fatal(err)
+	}
+	restorer := &Restorer{Remote: DefaultRemote, Backend: backend, InPlace: true}
+	restorer.RestoreFile(remoteFile, file, file)
+	if restorer.failed != 1 {
+		t.Errorf("failed = %v, want 1", restorer.failed)
+	}
+	if _, err := os.Lstat(file); !os.IsNotExist(err) {
+		t.Errorf("file not matching the synced checksum was restored: %v", err)
+	}
+	if entry, err := ReadState(file, DefaultRemote); err != nil || entry.Hash != synced.Hash || entry.Revision != synced.Revision {
+		t.Errorf("sync state = %+v, %v; want %+v", entry, err, synced)
+	}
+}
+
+func TestFindTaskNested(t *testing.T) {
+	testHome(t)
+	SaveTasks([]Task{{Path: "/data"}, {Path: "/data/photos", RemotePath: "Photos"}, {Path: "/data/photos-old"}})
+	for file, want := range map[string]string{
+		"/data/photos/2022/a.jpg": "/data/photos",
+		"/data/photos":            "/data/photos",
+		"/data/photos-old/b.jpg":  "/data/photos-old",
+		"/data/docs/c.txt":        "/data",
+		"/other/d.txt":            "/other/d.txt",
+	} {
+		if task := FindTask(file); task.Path != want {
+			t.Errorf("FindTask(%q) = %q, want %q", file, task.Path, want)
+		}
+	}
+}
@@ -0,0 +1,59 @@
+/*
+Copyright © 2022 NAME HERE <EMAIL ADDRESS>
+
+*/
+package cmd
+
+import (
+	"log"
+)
+
+//SharedDriver is implemented by backends able to store files in a shared
+//drive instead of the user own drive.
+type SharedDriver interface {
+	//SharedDrive returns a backend whose root folder is the shared drive
+	//with the given Id or name, and that root folder, named after the
+	//shared drive.
+	SharedDrive(idOrName string) (Backend, *RemoteFile, error)
+}
+
+//TaskBackend returns the backend of the given task remote. Tasks with a
+//shared drive are stored in it, all their remotes have to support shared
+//drives. Backends are reused from and added to the given map of backends.
+func TaskBackend(task Task, remoteName string, backends map[string]Backend) Backend {
+	backendKey := remoteName
+	if task.FullAccess() {
+		backendKey += "\x00full"
+	}
+	backend, ok := backends[backendKey]
+	if !ok {
+		backend = GetBackend(GetRemote(remoteName), task.FullAccess())
+		backends[backendKey] = backend
+	}
+	if task.SharedDrive == "" {
+		return backend
+	}
+	sharedDriver, ok := backend.(SharedDriver)
+	if !ok {
+		log.Fatalf("Remote %v can't store files in shared drives", remoteName)
+	}
+	key := remoteName + "\x00" + task.SharedDrive
+	if sharedBackend, ok := backends[key]; ok {
+		return sharedBackend
+	}
+	sharedBackend, _, err := sharedDriver.SharedDrive(task.SharedDrive)
+	if err != nil {
+		log.Fatalf("Unable to use shared drive %q of remote %v: %v", task.SharedDrive, remoteName, err)
+	}
+	backends[key] = sharedBackend
+	return sharedBackend
+}
+
+//sharedDriveName returns the name of the shared drive of the task, its Id
+//when the name isn't known.
+func (t Task) sharedDriveName() string {
+	if t.SharedDriveName != "" {
+		return t.SharedDriveName
+	}
+	return t.SharedDrive
+}
@@ -0,0 +1,552 @@
+/*
+Copyright © 2022 NAME HERE <EMAIL ADDRESS>
+
+*/
+package cmd
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+const (
+	//SnapshotLayout is the name of the snapshot folders, the UTC time the
+	//snapshot was taken. Names sort by time and hold no colon, which SMB
+	//and Windows remotes reject.
+	SnapshotLayout = "20060102T150405Z"
+	//legacySnapshotLayout is the name of the snapshot folders taken by
+	//older versions, the local time the snapshot was taken.
+	legacySnapshotLayout = "2006-01-02T15:04:05"
+	//SnapshotSuffix is added to the task name to get the remote folder
+	//holding its snapshots.
+	SnapshotSuffix = ".snapshots"
+)
+
+//Manifest lists every file and folder of a snapshot. A snapshot is the
+//remote folder named after its time, holding the files uploaded by that
+//snapshot, and its manifest file next to it named after the folder with a
+//".json" extension. The manifest is uploaded last, snapshots without one
+//are incomplete.
+type Manifest struct {
+	Task  string           `json:"task"`
+	Time  time.Time        `json:"time"`
+	Files []*ManifestEntry `json:"files"`
+}
+
+//ManifestEntry is a file, a folder or a symbolic link of a snapshot. Files
+//not modified since an earlier snapshot reference the remote file uploaded
+//by that snapshot.
+type ManifestEntry struct {
+	//Path is relative to the folder holding the task, folders are listed
+	//before their content.
+	Path  string `json:"path"`
+	IsDir bool   `json:"dir,omitempty"`
+	//ID is the remote file holding the content, uploaded by the Snapshot
+	//named snapshot.
+	ID         string      `json:"id,omitempty"`
+	Snapshot   string      `json:"snapshot,omitempty"`
+	Hash       string      `json:"sha256,omitempty"`
+	Size       int64       `json:"size,omitempty"`
+	MD5        string      `json:"md5,omitempty"`
+	LinkTarget string      `json:"link,omitempty"`
+	ModTime    time.Time   `json:"mtime"`
+	Mode       os.FileMode `json:"mode"`
+	UID        int         `json:"uid"`
+	GID        int         `json:"gid"`
+}
+
+//remoteFile returns the remote file holding the entry content with the
+//metadata of the snapshot file.
+func (e *ManifestEntry) remoteFile() *RemoteFile {
+	return &RemoteFile{
+		ID:         e.ID,
+		Name:       path.Base(e.Path),
+		IsDir:      e.IsDir,
+		Size:       e.Size,
+		MD5:        e.MD5,
+		LinkTarget: e.LinkTarget,
+		ModTime:    e.ModTime,
+		Mode:       e.Mode,
+		UID:        e.UID,
+		GID:        e.GID,
+	}
+}
+
+//snapshotState is the state of a snapshot being taken.
+type snapshotState struct {
+	name     string
+	manifest *Manifest
+	//base is the local folder holding the task
+	base string
+	//rootId is the remote folder holding the task snapshots
+	rootId string
+	//remote folders of the snapshot by path relative to base
+	folders map[string]string
+	//files of the earlier snapshots and of this one by content hash
+	uploaded map[string]*ManifestEntry
+}
+
+//snapshotRoot returns the folder holding the snapshots of the task at root
+//inside the remote folder parent, or nil if no snapshot was taken yet.
+func snapshotRoot(b Backend, parent, root string) (*RemoteFile, error) {
+	folder, err := findChild(b, parent, filepath.Base(root)+SnapshotSuffix)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	return folder, err
+}
+
+//listSnapshots returns the manifest files of the complete snapshots inside
+//the given folder, oldest first.
+func listSnapshots(b Backend, folderId string) ([]*RemoteFile, error) {
+	files, err := b.List(folderId)
+	if err != nil {
+		return nil, err
+	}
+	var manifests []*RemoteFile
+	for _, f := range files {
+		name := strings.TrimSuffix(f.Name, ".json")
+		if _, ok := snapshotTime(name); ok && !f.IsDir && name != f.Name {
+			manifests = append(manifests, f)
+		}
+	}
+	sort.Slice(manifests, func(i, j int) bool {
+		ti, _ := snapshotTime(snapshotName(manifests[i]))
+		tj, _ := snapshotTime(snapshotName(manifests[j]))
+		return ti.Before(tj)
+	})
+	return manifests, nil
+}
+
+//snapshotTime returns the time a snapshot was taken from its name.
+func snapshotTime(name string) (time.Time, bool) {
+	if t, err := time.Parse(SnapshotLayout, name); err == nil {
+		return t, true
+	}
+	if t, err := time.ParseInLocation(legacySnapshotLayout, name, time.Local); err == nil {
+		return t, true
+	}
+	return time.Time{}, false
+}
+
+//readManifest downloads and parses a snapshot manifest.
+func readManifest(b Backend, manifestFile *RemoteFile) (*Manifest, error) {
+	var data bytes.Buffer
+	if err := b.Download(manifestFile.ID, &data); err != nil {
+		return nil, err
+	}
+	manifest := &Manifest{}
+	if err := json.Unmarshal(data.Bytes(), manifest); err != nil {
+		return nil, fmt.Errorf("malformed manifest %q: %v", manifestFile.Name, err)
+	}
+	return manifest, nil
+}
+
+//snapshotName returns the name of a snapshot from its manifest file name.
+func snapshotName(manifestFile *RemoteFile) string {
+	return strings.TrimSuffix(manifestFile.Name, ".json")
+}
+
+//findSnapshot returns the manifest file of the latest snapshot taken at or
+//before the given time.
+func findSnapshot(manifests []*RemoteFile, at time.Time) *RemoteFile {
+	var found *RemoteFile
+	for _, manifestFile := range manifests {
+		taken, _ := snapshotTime(snapshotName(manifestFile))
+		if taken.After(at) {
+			break
+		}
+		found = manifestFile
+	}
+	return found
+}
+
+//LoadSnapshot returns the manifest of the last snapshot of the task taken
+//at or before the given time, or of its latest snapshot when at is empty.
+func LoadSnapshot(b Backend, remote string, task Task, at string) *Manifest {
+	taskPath := task.Path
+	parent, err := ResolveRemotePath(b, task.RemotePath, false)
+	if err != nil {
+		log.Fatalf("Unable to find the snapshots of %q in %v: %v", taskPath, remote, err)
+	}
+	rootFolder, err := snapshotRoot(b, parent, taskPath)
+	if err != nil {
+		log.Fatalf("Unable to find the snapshots of %q in %v: %v", taskPath, remote, err)
+	}
+	var manifests []*RemoteFile
+	if rootFolder != nil {
+		if manifests, err = listSnapshots(b, rootFolder.ID); err != nil {
+			log.Fatalf("Unable to list the snapshots of %q in %v: %v", taskPath, remote, err)
+		}
+	}
+	if len(manifests) == 0 {
+		log.Fatalf("No snapshot of %q in %v", taskPath, remote)
+	}
+	manifestFile := manifests[len(manifests)-1]
+	if at != "" {
+		t, err := ParseTime(at)
+		if err != nil {
+			log.Fatalf("Unable to find snapshot: %v", err)
+		}
+		if manifestFile = findSnapshot(manifests, t); manifestFile == nil {
+			log.Fatalf("No snapshot of %q was taken at or before %v", taskPath, at)
+		}
+	}
+	manifest, err := readManifest(b, manifestFile)
+	if err != nil {
+		log.Fatalf("Unable to read snapshot %v: %v", snapshotName(manifestFile), err)
+	}
+	return manifest
+}
+
+//snapshot takes a snapshot of the task file or folder: files not modified
+//since the last snapshot are only listed in the new manifest, the others
+//are uploaded inside the new snapshot folder.
+func (s *Syncer) snapshot(root string) {
+	now := time.Now()
+	s.snap = &snapshotState{
+		name:     now.UTC().Format(SnapshotLayout),
+		manifest: &Manifest{Task: root, Time: now},
+		base:     path.Dir(root),
+		folders:  make(map[string]string),
+		uploaded: make(map[string]*ManifestEntry),
+	}
+	defer func() { s.snap = nil }()
+
+	var rootFolder *RemoteFile
+	var err error
+	//dry runs don't call the remote, the earlier snapshots aren't known
+	if !s.DryRun {
+		rootFolder, err = snapshotRoot(s.Backend, s.root, root)
+	}
+	if err != nil {
+		log.Fatalf("Unable to find the snapshots of %q in %v: %v", root, s.Remote, err)
+	}
+	if rootFolder == nil && !s.DryRun {
+		rootFolder, err = s.Backend.CreateFolder(&RemoteFile{Name: filepath.Base(root) + SnapshotSuffix, Parent: s.root})
+		if err != nil {
+			log.Fatalf("Unable to create remote folder: %v", err)
+		}
+	}
+	if rootFolder != nil {
+		s.snap.rootId = rootFolder.ID
+		manifests, err := listSnapshots(s.Backend, rootFolder.ID)
+		if err != nil {
+			log.Fatalf("Unable to list the snapshots of %q in %v: %v", root, s.Remote, err)
+		}
+		if len(manifests) > 0 {
+			if snapshotName(manifests[len(manifests)-1]) == s.snap.name {
+				log.Fatalf("Snapshot %v of %q was already taken in %v", s.snap.name, root, s.Remote)
+			}
+			last, err := readManifest(s.Backend, manifests[len(manifests)-1])
+			if err != nil {
+				log.Fatalf("Unable to read the last snapshot of %q in %v: %v", root, s.Remote, err)
+			}
+			for _, entry := range last.Files {
+				if entry.ID != "" {
+					s.snap.uploaded[entry.Hash] = entry
+				}
+			}
+		}
+	}
+
+	fileStats, err := os.Stat(root)
+	if err != nil {
+		log.Fatalf("Unable to get file or dir %q stats: %v", root, err)
+	}
+	if fileStats.IsDir() {
+		s.snapshotDir(root)
+	} else {
+		s.snapshotFile(root)
+	}
+	if s.DryRun {
+		s.printPlan(root)
+		return
+	}
+
+	manifestData, err := json.MarshalIndent(s.snap.manifest, "", "  ")
+	if err != nil {
+		log.Fatalf("Unable to encode the snapshot manifest: %v", err)
+	}
+	_, err = s.Backend.Upload(&RemoteFile{Name: s.snap.name + ".json", Parent: s.snap.rootId}, bytes.NewReader(manifestData))
+	if err != nil {
+		log.Fatalf("Unable to upload the snapshot manifest to %v: %v", s.Remote, err)
+	}
+	fmt.Printf("Snapshot %v of %q taken in %v\n", s.snap.name, root, s.Remote)
+}
+
+//snapshotDir adds a folder and its content to the snapshot.
+func (s *Syncer) snapshotDir(dir string) {
+	if !s.enterDir(dir) {
+		fmt.Printf("Folder %q links to a folder being synced and was skipped to avoid a loop\n", dir)
+		return
+	}
+	defer s.leaveDir(dir)
+	s.addEntry(dir, &ManifestEntry{IsDir: true})
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		log.Fatalf("Unable to read dir: %v", err)
+	}
+	for _, entry := range entries {
+		file := path.Join(dir, entry.Name())
+		fileType := entry.Type()
+		if fileType&fs.ModeSymlink != 0 && (s.Symlinks == "" || s.Symlinks == SymlinkFollow) {
+			fileStats, err := os.Stat(file)
+			if err != nil {
+				fmt.Printf("Symbolic link %q is broken and was skipped\n", file)
+				continue
+			}
+			fileType = fileStats.Mode().Type()
+		}
+		if s.ignore.Ignored(file, fileType.IsDir()) {
+			continue
+		}
+		switch {
+		case fileType.IsDir():
+			s.snapshotDir(file)
+		case fileType.IsRegular():
+			s.snapshotFile(file)
+		case fileType&fs.ModeSymlink != 0 && s.Symlinks == SymlinkLink:
+			target, err := os.Readlink(file)
+			if err != nil {
+				log.Fatalf("Unable to read symbolic link %q: %v", file, err)
+			}
+			s.snap.manifest.Files = append(s.snap.manifest.Files, &ManifestEntry{
+				Path:       s.snapshotPath(file),
+				LinkTarget: target,
+			})
+		case fileType&fs.ModeSymlink != 0:
+			fmt.Printf("Symbolic link %q was skipped\n", file)
+		default:
+			fmt.Printf("%q isn't a regular file and was skipped\n", file)
+		}
+	}
+}
+
+//snapshotFile adds a file to the snapshot, it's only uploaded if no
+//earlier snapshot holds the same content.
+func (s *Syncer) snapshotFile(file string) {
+	hash := HashFile(file)
+	if earlier, ok := s.snap.uploaded[hash]; ok {
+		fmt.Printf("File %q is in snapshot %v and hasn't been modified in %v\n", file, earlier.Snapshot, s.Remote)
+		s.addEntry(file, &ManifestEntry{
+			ID:       earlier.ID,
+			Snapshot: earlier.Snapshot,
+			Hash:     hash,
+			Size:     earlier.Size,
+			MD5:      earlier.MD5,
+		})
+		if s.DryRun {
+			s.plan.unchanged++
+			s.plan.unchangedBytes += earlier.Size
+		}
+		return
+	}
+	if s.DryRun {
+		size := fileSize(file)
+		fmt.Printf("Would upload file %q (%v) to %v\n", file, formatSize(size), s.Remote)
+		s.plan.uploads++
+		s.plan.uploadBytes += size
+		return
+	}
+
+	f, err := os.Open(file)
+	if err != nil {
+		log.Fatalf("Unable to open file %q: %v", file, err)
+	}
+	defer f.Close()
+	meta := localMeta(file)
+	meta.Name, meta.Parent = filepath.Base(file), s.snapshotFolder(path.Dir(s.snapshotPath(file)))
+	remoteFile, err := s.Backend.Upload(meta, f)
+	if err != nil {
+		log.Fatalf("Unable to create file %q in %v: %v", meta.Name, s.Remote, err)
+	}
+	fmt.Printf("Uploaded file %q Id %v to %v\n", file, remoteFile.ID, s.Remote)
+	entry := &ManifestEntry{
+		ID:       remoteFile.ID,
+		Snapshot: s.snap.name,
+		Hash:     hash,
+		Size:     fileSize(file),
+		MD5:      remoteFile.MD5,
+	}
+	s.addEntry(file, entry)
+	s.snap.uploaded[hash] = entry
+}
+
+//addEntry adds a file or folder to the snapshot manifest with its local
+//metadata.
+func (s *Syncer) addEntry(file string, entry *ManifestEntry) {
+	meta := localMeta(file)
+	entry.Path = s.snapshotPath(file)
+	entry.ModTime, entry.Mode, entry.UID, entry.GID = meta.ModTime, meta.Mode, meta.UID, meta.GID
+	s.snap.manifest.Files = append(s.snap.manifest.Files, entry)
+}
+
+//snapshotPath returns the path of a file relative to the folder holding
+//the task.
+func (s *Syncer) snapshotPath(file string) string {
+	return strings.TrimPrefix(file, strings.TrimSuffix(s.snap.base, "/")+"/")
+}
+
+//snapshotFolder returns the remote folder of the snapshot at the given
+//path relative to the folder holding the task, creating it if needed.
+func (s *Syncer) snapshotFolder(dir string) string {
+	if folderId, ok := s.snap.folders[dir]; ok {
+		return folderId
+	}
+	parent, name := s.snap.rootId, s.snap.name
+	if dir != "." {
+		parent, name = s.snapshotFolder(path.Dir(dir)), path.Base(dir)
+	}
+	folder, err := s.Backend.CreateFolder(&RemoteFile{Name: name, Parent: parent})
+	if err != nil {
+		log.Fatalf("Unable to create remote folder: %v", err)
+	}
+	s.snap.folders[dir] = folder.ID
+	return folder.ID
+}
+
+//RestoreSnapshot restores the files and folders of a snapshot holding file
+//or inside it into target.
+func (r *Restorer) RestoreSnapshot(manifest *Manifest, file, target string) {
+	base := path.Dir(manifest.Task)
+	var dirs []*ManifestEntry
+	restored := 0
+	for _, entry := range manifest.Files {
+		source := path.Join(base, entry.Path)
+		if source != file && !strings.HasPrefix(source, file+"/") {
+			continue
+		}
+		restored++
+		dest := target + strings.TrimPrefix(source, file)
+		switch {
+		case entry.IsDir:
+			if err := os.MkdirAll(dest, 0750); err != nil {
+				log.Fatalf("Unable to create dir %q: %v", dest, err)
+			}
+			dirs = append(dirs, entry)
+		case entry.LinkTarget != "":
+			r.RestoreLink(entry.remoteFile(), dest)
+		default:
+			r.restoreSnapshotFile(entry, dest)
+		}
+	}
+	if restored == 0 {
+		log.Fatalf("%q isn't in the snapshot of %v", file, manifest.Time.Local().Format("2006-01-02 15:04:05"))
+	}
+	//folders get their metadata back once their content is restored
+	for i := len(dirs) - 1; i >= 0; i-- {
+		dest := target + strings.TrimPrefix(path.Join(base, dirs[i].Path), file)
+		if err := applyMeta(dest, dirs[i].remoteFile()); err != nil {
+			log.Fatalf("Unable to restore the metadata of %q: %v", dest, err)
+		}
+	}
+}
+
+//restoreSnapshotFile downloads a snapshot file to file.
+func (r *Restorer) restoreSnapshotFile(entry *ManifestEntry, file string) {
+	if fileStats, err := os.Lstat(file); err == nil {
+		if fileStats.Mode().IsRegular() && HashFile(file) == entry.Hash {
+			fmt.Printf("File %q is already restored\n", file)
+			return
+		}
+		if !r.Force {
+			fmt.Printf("File %q exists and was kept, use --force to overwrite it\n", file)
+			return
+		}
+	}
+	if err := os.MkdirAll(path.Dir(file), 0750); err != nil {
+		log.Fatalf("Unable to create dir %q: %v", path.Dir(file), err)
+	}
+	_, err := downloadFile(r.Backend, entry.remoteFile(), file)
+	if errors.Is(err, errChecksum) {
+		r.failed++
+		fmt.Printf("File %q doesn't match the remote MD5 checksum and wasn't restored\n", file)
+		return
+	}
+	if err != nil {
+		log.Fatalf("Unable to download %q from %v: %v", file, r.Remote, err)
+	}
+	fmt.Printf("Restored file %q from %v snapshot %v\n", file, r.Remote, entry.Snapshot)
+}
+
+// snapshotsCmd represents the snapshots command
+var snapshotsCmd = &cobra.Command{
+	Use:   "snapshots [task]",
+	Short: "List the snapshots of a task",
+	Long: `List the snapshots of a task added with "--snapshot":
+"dsync snapshots [task] [--from remote]"
+A snapshot is restored with "dsync restore [task|file|dir] --snapshot [time]".`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		taskPath, err := filepath.Abs(args[0])
+		if err != nil {
+			log.Fatalf("Unable to get file or directory %q: %v", args[0], err)
+		}
+		task := FindTask(taskPath)
+		remoteName, _ := cmd.Flags().GetString("from")
+		if remoteName == "" {
+			remoteName = task.Remotes()[0]
+		}
+		backend := TaskBackend(task, remoteName, make(map[string]Backend))
+		parent, err := ResolveRemotePath(backend, task.RemotePath, false)
+		var rootFolder *RemoteFile
+		if err == nil {
+			rootFolder, err = snapshotRoot(backend, parent, task.Path)
+		} else if errors.Is(err, os.ErrNotExist) {
+			err = nil
+		}
+		if err != nil {
+			log.Fatalf("Unable to find the snapshots of %q in %v: %v", task.Path, remoteName, err)
+		}
+		if rootFolder == nil {
+			fmt.Printf("No snapshot of %q in %v\n", task.Path, remoteName)
+			return
+		}
+		manifests, err := listSnapshots(backend, rootFolder.ID)
+		if err != nil {
+			log.Fatalf("Unable to list the snapshots of %q in %v: %v", task.Path, remoteName, err)
+		}
+		fmt.Printf("Snapshots of %q in %v:\n", task.Path, remoteName)
+		for _, manifestFile := range manifests {
+			manifest, err := readManifest(backend, manifestFile)
+			if err != nil {
+				log.Fatalf("Unable to read snapshot %v: %v", snapshotName(manifestFile), err)
+			}
+			files := 0
+			var size int64
+			uploaded := make(map[string]bool)
+			for _, entry := range manifest.Files {
+				if entry.ID == "" {
+					continue
+				}
+				files++
+				size += entry.Size
+				if entry.Snapshot == snapshotName(manifestFile) {
+					uploaded[entry.ID] = true
+				}
+			}
+			taken, _ := snapshotTime(snapshotName(manifestFile))
+			fmt.Printf("  %v  %v  %v files (%v), %v uploaded\n", snapshotName(manifestFile), taken.Local().Format("2006-01-02 15:04:05"), files, formatSize(size), len(uploaded))
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(snapshotsCmd)
+
+	snapshotsCmd.Flags().StringP("from", "f", "", "Remote holding the snapshots (default the first task remote)")
+}
@@ -0,0 +1,56 @@
+/*
+Copyright © 2022 NAME HERE <EMAIL ADDRESS>
+
+*/
+package cmd
+
+import (
+	"os"
+	"path"
+	"regexp"
+	"testing"
+	"time"
+)
+
+func TestSnapshotNames(t *testing.T) {
+	testHome(t)
+	remoteDir := t.TempDir()
+	backend := NewLocalBackend(remoteDir)
+	src := path.Join(t.TempDir(), "src")
+	writeFile(t, path.Join(src, "a.txt"), "a")
+	(&Syncer{Remote: "local", Backend: backend, Snapshot: true}).Sync(src)
+
+	entries, err := os.ReadDir(path.Join(remoteDir, "src"+SnapshotSuffix))
+	if err != nil {
+		t.Fatal(err)
+	}
+	utcName := regexp.MustCompile(`^\d{8}T\d{6}Z(\.json)?$`)
+	for _, entry := range entries {
+		if !utcName.MatchString(entry.Name()) {
+			t.Errorf("snapshot file %q isn't named after the UTC time", entry.Name())
+		}
+	}
+
+	//snapshots of older versions are named after the local time
+	taken := time.Now().Add(-time.Hour).Truncate(time.Second)
+	writeFile(t, path.Join(remoteDir, "src"+SnapshotSuffix, taken.Format(legacySnapshotLayout)+".json"), "{}")
+	writeFile(t, path.Join(remoteDir, "src"+SnapshotSuffix, taken.Add(-time.Hour).UTC().Format(SnapshotLayout)+".json"), "{}")
+	manifests, err := listSnapshots(backend, "src"+SnapshotSuffix)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(manifests) != 3 {
+		t.Fatalf("listed %v snapshots, want 3", len(manifests))
+	}
+	var times []time.Time
+	for _, manifestFile := range manifests {
+		taken, ok := snapshotTime(snapshotName(manifestFile))
+		if !ok {
+			t.Fatalf("snapshot %q has no time", manifestFile.Name)
+		}
+		times = append(times, taken)
+	}
+	if !times[0].Equal(taken.Add(-time.Hour)) || !times[1].Equal(taken) || !times[2].After(taken) {
+		t.Errorf("snapshot times %v aren't sorted", times)
+	}
+}
@@ -0,0 +1,524 @@
+/*
+Copyright © 2022 NAME HERE <EMAIL ADDRESS>
+
+*/
+package cmd
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"time"
+)
+
+//sync state files, the journal holds the changes made since the state file
+//was last written
+var (
+	StateFile    = path.Join(UserHome, ".dsync/state.json")
+	StateJournal = path.Join(UserHome, ".dsync/state.journal")
+)
+
+//StateEntry is the sync state of a file or folder in a remote.
+type StateEntry struct {
+	IsDir bool   `json:"dir,omitempty"`
+	ID    string `json:"id"`
+	//Hash is the sha256 hash of the synced content, of the target of
+	//symbolic links.
+	Hash     string    `json:"hash,omitempty"`
+	Revision string    `json:"revision,omitempty"`
+	Size     int64     `json:"size,omitempty"`
+	ModTime  time.Time `json:"mtime"`
+	//Synced is the time of the last sync.
+	Synced time.Time `json:"synced"`
+}
+
+//TaskState is the sync state of a task.
+type TaskState struct {
+	//Files holds the state of the synced files and folders by remote and
+	//by path relative to the task path, "." is the task path itself.
+	Files map[string]map[string]*StateEntry `json:"files"`
+	//Tokens are the changes page tokens of the two-way syncs by remote.
+	Tokens map[string]string `json:"tokens,omitempty"`
+}
+
+//stateOp is a single change of the sync state.
+type stateOp struct {
+	//Op is "task" to add a task, "put" and "del" to set and remove the
+	//state of Path and "token" to set the changes page token.
+	Op     string      `json:"op"`
+	Task   string      `json:"task"`
+	Remote string      `json:"remote,omitempty"`
+	Path   string      `json:"path,omitempty"`
+	Entry  *StateEntry `json:"entry,omitempty"`
+	Token  string      `json:"token,omitempty"`
+}
+
+//stateStore is the sync state of all tasks by task path. Every change is
+//a line of the journal holding all its operations, so it's applied whole
+//or not at all, and the journal is folded into the state file once loaded.
+//The journal is locked while it's loaded, so overlapping runs take turns.
+type stateStore struct {
+	Tasks   map[string]*TaskState `json:"tasks"`
+	journal *os.File
+	//dirs indexes the paths of every task and remote by parent path
+	dirs map[string]map[string]dirIndex
+}
+
+//dirIndex holds the paths with a sync state inside a task and the folders
+//holding them by parent path, to find the files of a folder without going
+//through the whole state.
+type dirIndex map[string]map[string]bool
+
+//add adds rel and the folders holding it.
+func (ix dirIndex) add(rel string) {
+	for rel != "." {
+		parent := path.Dir(rel)
+		if ix[parent] == nil {
+			ix[parent] = make(map[string]bool)
+		}
+		if ix[parent][rel] {
+			return
+		}
+		ix[parent][rel] = true
+		rel = parent
+	}
+}
+
+//remove removes rel, once it holds no indexed path and has no sync state
+//in entries, and the folders left empty holding it.
+func (ix dirIndex) remove(rel string, entries map[string]*StateEntry) {
+	for rel != "." && len(ix[rel]) == 0 {
+		if _, ok := entries[rel]; ok {
+			return
+		}
+		parent := path.Dir(rel)
+		delete(ix[parent], rel)
+		if len(ix[parent]) == 0 {
+			delete(ix, parent)
+		}
+		rel = parent
+	}
+}
+
+//walk calls fn with every path inside rel.
+func (ix dirIndex) walk(rel string, fn func(rel string)) {
+	for child := range ix[rel] {
+		fn(child)
+		ix.walk(child, fn)
+	}
+}
+
+//syncState is the sync state loaded by loadState
+var syncState *stateStore
+
+//loadState returns the sync state, reading it and folding its journal into
+//the state file the first time.
+func loadState() *stateStore {
+	if syncState != nil {
+		return syncState
+	}
+	if err := os.MkdirAll(path.Dir(StateJournal), 0750); err != nil {
+		log.Fatalf("Unable to create dir %q: %v", path.Dir(StateJournal), err)
+	}
+	journal, err := os.OpenFile(StateJournal, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		log.Fatalf("Unable to open sync state journal %q: %v", StateJournal, err)
+	}
+	//the lock is released when the process exits
+	if err := lockFile(journal); err != nil {
+		log.Fatalf("Unable to lock sync state journal %q: %v", StateJournal, err)
+	}
+	st := &stateStore{Tasks: make(map[string]*TaskState), journal: journal, dirs: make(map[string]map[string]dirIndex)}
+	stateData, err := os.ReadFile(StateFile)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		log.Fatalf("Unable to read sync state file %q: %v", StateFile, err)
+	}
+	if err == nil {
+		if err := json.Unmarshal(stateData, st); err != nil {
+			log.Fatalf("Unable to parse sync state file %q: %v", StateFile, err)
+		}
+	}
+	for taskPath, task := range st.Tasks {
+		for remote, files := range task.Files {
+			for rel := range files {
+				st.dirIndex(taskPath, remote).add(rel)
+			}
+		}
+	}
+	if st.replay() {
+		st.save()
+	}
+	syncState = st
+	return st
+}
+
+//dirIndex returns the index of the paths of the given task and remote.
+func (st *stateStore) dirIndex(taskPath, remote string) dirIndex {
+	if st.dirs[taskPath] == nil {
+		st.dirs[taskPath] = make(map[string]dirIndex)
+	}
+	if st.dirs[taskPath][remote] == nil {
+		st.dirs[taskPath][remote] = make(dirIndex)
+	}
+	return st.dirs[taskPath][remote]
+}
+
+//replay applies the changes of the journal, a change cut by a crash is
+//dropped. It returns whether the journal isn't empty, it has to be emptied
+//before new changes are appended then.
+func (st *stateStore) replay() bool {
+	f, err := os.Open(StateJournal)
+	if errors.Is(err, os.ErrNotExist) {
+		return false
+	}
+	if err != nil {
+		log.Fatalf("Unable to read sync state journal %q: %v", StateJournal, err)
+	}
+	defer f.Close()
+	journalStats, err := f.Stat()
+	if err != nil {
+		log.Fatalf("Unable to read sync state journal %q: %v", StateJournal, err)
+	}
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 64*1024*1024)
+	for scanner.Scan() {
+		var ops []stateOp
+		if err := json.Unmarshal(scanner.Bytes(), &ops); err != nil {
+			break
+		}
+		for _, op := range ops {
+			st.apply(op)
+		}
+	}
+	return journalStats.Size() > 0
+}
+
+//save writes the whole state to a new state file that replaces the old
+//one, then empties the journal.
+func (st *stateStore) save() {
+	stateData, err := json.Marshal(st)
+	if err != nil {
+		log.Fatalf("Unable to encode sync state: %v", err)
+	}
+	tmp := StateFile + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		log.Fatalf("Unable to write sync state file: %v", err)
+	}
+	if _, err := f.Write(stateData); err != nil {
+		log.Fatalf("Unable to write sync state file: %v", err)
+	}
+	if err := f.Sync(); err != nil {
+		log.Fatalf("Unable to write sync state file: %v", err)
+	}
+	f.Close()
+	if err := os.Rename(tmp, StateFile); err != nil {
+		log.Fatalf("Unable to replace sync state file: %v", err)
+	}
+	//replaying the journal again is harmless if it isn't emptied
+	if err := st.journal.Truncate(0); err != nil {
+		log.Fatalf("Unable to empty sync state journal: %v", err)
+	}
+}
+
+//commit applies the given operations and appends them to the journal as a
+//single change.
+func (st *stateStore) commit(ops ...stateOp) {
+	if len(ops) == 0 {
+		return
+	}
+	opsData, err := json.Marshal(ops)
+	if err != nil {
+		log.Fatalf("Unable to encode sync state: %v", err)
+	}
+	if _, err := st.journal.Write(append(opsData, '\n')); err != nil {
+		log.Fatalf("Unable to write sync state journal: %v", err)
+	}
+	//a change is kept once the remote is changed, even on power loss
+	if err := st.journal.Sync(); err != nil {
+		log.Fatalf("Unable to write sync state journal: %v", err)
+	}
+	for _, op := range ops {
+		st.apply(op)
+	}
+}
+
+//apply applies an operation to the state in memory.
+func (st *stateStore) apply(op stateOp) {
+	task := st.Tasks[op.Task]
+	if task == nil {
+		task = &TaskState{Files: make(map[string]map[string]*StateEntry)}
+		st.Tasks[op.Task] = task
+	}
+	switch op.Op {
+	case "put":
+		if task.Files[op.Remote] == nil {
+			task.Files[op.Remote] = make(map[string]*StateEntry)
+		}
+		task.Files[op.Remote][op.Path] = op.Entry
+		st.dirIndex(op.Task, op.Remote).add(op.Path)
+	case "del":
+		delete(task.Files[op.Remote], op.Path)
+		st.dirIndex(op.Task, op.Remote).remove(op.Path, task.Files[op.Remote])
+	case "token":
+		if task.Tokens == nil {
+			task.Tokens = make(map[string]string)
+		}
+		task.Tokens[op.Remote] = op.Token
+	}
+}
+
+//taskOf returns the path of the task keeping the state of file, the
+//innermost task with a state holding it, and file relative to it. The path
+//is empty when no such task exists.
+func (st *stateStore) taskOf(file string) (string, string) {
+	var taskPaths []string
+	for taskPath := range st.Tasks {
+		taskPaths = append(taskPaths, taskPath)
+	}
+	taskPath := innermost(file, taskPaths)
+	if taskPath == "" {
+		return "", ""
+	}
+	return taskPath, relPath(taskPath, file)
+}
+
+//innermost returns the longest of the given paths holding file.
+func innermost(file string, paths []string) string {
+	found := ""
+	for _, p := range paths {
+		if (file == p || strings.HasPrefix(file, strings.TrimSuffix(p, "/")+"/")) && len(p) > len(found) {
+			found = p
+		}
+	}
+	return found
+}
+
+//relPath returns file relative to the task path.
+func relPath(taskPath, file string) string {
+	if file == taskPath {
+		return "."
+	}
+	return strings.TrimPrefix(file, strings.TrimSuffix(taskPath, "/")+"/")
+}
+
+//addTask adds a task to the state, the state of its files kept by the
+//task holding it moves to it.
+func (st *stateStore) addTask(taskPath string) []stateOp {
+	if _, ok := st.Tasks[taskPath]; ok {
+		return nil
+	}
+	ops := []stateOp{{Op: "task", Task: taskPath}}
+	outer, _ := st.taskOf(taskPath)
+	if task, ok := st.Tasks[outer]; ok {
+		for remote, files := range task.Files {
+			for rel, entry := range files {
+				file := path.Join(outer, rel)
+				if file != taskPath && !strings.HasPrefix(file, taskPath+"/") {
+					continue
+				}
+				ops = append(ops,
+					stateOp{Op: "put", Task: taskPath, Remote: remote, Path: relPath(taskPath, file), Entry: entry},
+					stateOp{Op: "del", Task: outer, Remote: remote, Path: rel})
+			}
+		}
+	}
+	return ops
+}
+
+//get returns the state of file in the given remote.
+func (st *stateStore) get(file, remote string) (*StateEntry, bool) {
+	taskPath, rel := st.taskOf(file)
+	if taskPath == "" {
+		return nil, false
+	}
+	entry, ok := st.Tasks[taskPath].Files[remote][rel]
+	return entry, ok
+}
+
+//put returns the operations setting the state of file in the given remote.
+//Files outside of every task with a state belong to the innermost task of
+//the tasks list holding them, or are a task of their own.
+func (st *stateStore) put(file, remote string, entry *StateEntry) []stateOp {
+	var ops []stateOp
+	taskPath, rel := st.taskOf(file)
+	if taskPath == "" {
+		var taskPaths []string
+		for _, task := range GetTasks() {
+			taskPaths = append(taskPaths, task.Path)
+		}
+		if taskPath = innermost(file, taskPaths); taskPath == "" {
+			taskPath = file
+		}
+		rel = relPath(taskPath, file)
+		ops = st.addTask(taskPath)
+	}
+	return append(ops, stateOp{Op: "put", Task: taskPath, Remote: remote, Path: rel, Entry: entry})
+}
+
+//under returns the paths of the files and folders with a state in the
+//given remote that are file or inside it, sorted.
+func (st *stateStore) under(file, remote string) []string {
+	var files []string
+	for taskPath, task := range st.Tasks {
+		entries := task.Files[remote]
+		rel := ""
+		switch {
+		case innermost(taskPath, []string{file}) != "":
+			//tasks inside file
+			rel = "."
+		case innermost(file, []string{taskPath}) != "":
+			rel = relPath(taskPath, file)
+		default:
+			continue
+		}
+		if _, ok := entries[rel]; ok {
+			files = append(files, path.Join(taskPath, rel))
+		}
+		st.dirIndex(taskPath, remote).walk(rel, func(rel string) {
+			if _, ok := entries[rel]; ok {
+				files = append(files, path.Join(taskPath, rel))
+			}
+		})
+	}
+	sort.Strings(files)
+	return files
+}
+
+//children returns the paths of the files and folders with a state in the
+//given remote directly inside dir.
+func (st *stateStore) children(dir, remote string) []string {
+	var files []string
+	for taskPath, task := range st.Tasks {
+		entries := task.Files[remote]
+		if path.Dir(taskPath) == dir {
+			if _, ok := entries["."]; ok {
+				files = append(files, taskPath)
+			}
+		}
+		if innermost(dir, []string{taskPath}) == "" {
+			continue
+		}
+		for rel := range st.dirIndex(taskPath, remote)[relPath(taskPath, dir)] {
+			if _, ok := entries[rel]; ok {
+				files = append(files, path.Join(taskPath, rel))
+			}
+		}
+	}
+	return files
+}
+
+//del returns the operations removing the state of file in the given
+//remote, and of every file inside it.
+func (st *stateStore) del(file, remote string) []stateOp {
+	var ops []stateOp
+	for _, source := range st.under(file, remote) {
+		taskPath, rel := st.taskOf(source)
+		ops = append(ops, stateOp{Op: "del", Task: taskPath, Remote: remote, Path: rel})
+	}
+	return ops
+}
+
+//ReadState returns the sync state of the given file or folder in the given
+//remote, or a wrapped os.ErrNotExist if it was never synced.
+func ReadState(file, remote string) (*StateEntry, error) {
+	entry, ok := loadState().get(file, remote)
+	if !ok {
+		return nil, fmt.Errorf("no sync state of %q in %v: %w", file, remote, os.ErrNotExist)
+	}
+	return entry, nil
+}
+
+//WriteState stores the sync state of the given file or folder in the given
+//remote.
+func WriteState(file, remote string, entry *StateEntry) {
+	entry.Synced = time.Now()
+	st := loadState()
+	st.commit(st.put(file, remote, entry)...)
+}
+
+//AddStateTask adds a task to the sync state, files synced inside it by
+//another task keep their state.
+func AddStateTask(taskPath string) {
+	st := loadState()
+	st.commit(st.addTask(taskPath)...)
+}
+
+//RemoveState removes the sync state of the given file or folder in the
+//given remote, and of every file inside it.
+func RemoveState(file, remote string) {
+	st := loadState()
+	st.commit(st.del(file, remote)...)
+}
+
+//MoveState moves the sync state of the given file or folder in the given
+//remote, and of every file inside it, to target.
+func MoveState(file, target, remote string) {
+	st := loadState()
+	ops := st.del(file, remote)
+	for _, source := range st.under(file, remote) {
+		entry, _ := st.get(source, remote)
+		ops = append(ops, st.put(target+strings.TrimPrefix(source, file), remote, entry)...)
+	}
+	st.commit(ops...)
+}
+
+//walkState calls fn with every file and folder inside root with a sync
+//state in the given remote, skipping ignored folders. Files may not exist
+//anymore.
+func walkState(root, remote string, ignore *Ignorer, fn func(source string, isFolder bool)) {
+	st := loadState()
+	ignored := make(map[string]bool)
+	isIgnored := func(dir string) bool {
+		for ; dir != root && dir != "/" && dir != "."; dir = path.Dir(dir) {
+			skip, ok := ignored[dir]
+			if !ok {
+				skip = ignore.Ignored(dir, true)
+				ignored[dir] = skip
+			}
+			if skip {
+				return true
+			}
+		}
+		return false
+	}
+	for _, source := range st.under(root, remote) {
+		if source == root || isIgnored(path.Dir(source)) {
+			continue
+		}
+		entry, _ := st.get(source, remote)
+		fn(source, entry.IsDir)
+	}
+}
+
+//cleanState removes the sync state the given remote keeps for files and
+//folders of dir that don't exist anymore.
+func cleanState(dir, remote string) {
+	for _, source := range loadState().children(dir, remote) {
+		if _, err := os.Lstat(source); os.IsNotExist(err) {
+			RemoveState(source, remote)
+		}
+	}
+}
+
+//ReadChangesToken returns the changes page token saved by the last two-way
+//sync of the given task path, or a wrapped os.ErrNotExist.
+func ReadChangesToken(taskPath, remote string) (string, error) {
+	if task, ok := loadState().Tasks[taskPath]; ok && task.Tokens[remote] != "" {
+		return task.Tokens[remote], nil
+	}
+	return "", fmt.Errorf("no changes page token of %q in %v: %w", taskPath, remote, os.ErrNotExist)
+}
+
+//SaveChangesToken stores the changes page token of the given task path.
+func SaveChangesToken(taskPath, remote, token string) {
+	st := loadState()
+	ops := st.addTask(taskPath)
+	st.commit(append(ops, stateOp{Op: "token", Task: taskPath, Remote: remote, Token: token})...)
+}
@@ -0,0 +1,25 @@
+//go:build !windows
+
+/*
+Copyright © 2022 NAME HERE <EMAIL ADDRESS>
+
+*/
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"syscall"
+)
+
+//lockFile takes an exclusive lock on f, waiting for the process holding it
+//to release it.
+func lockFile(f *os.File) error {
+	err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB)
+	if !errors.Is(err, syscall.EWOULDBLOCK) {
+		return err
+	}
+	fmt.Println("Waiting for another dsync run to finish...")
+	return syscall.Flock(int(f.Fd()), syscall.LOCK_EX)
+}
@@ -0,0 +1,33 @@
+//go:build !windows
+
+/*
+Copyright © 2022 NAME HERE <EMAIL ADDRESS>
+
+*/
+package cmd
+
+import (
+	"errors"
+	"os"
+	"syscall"
+	"testing"
+)
+
+func TestStateLock(t *testing.T) {
+	testHome(t)
+	loadState()
+
+	//another run can't lock the journal while the state is loaded
+	journal, err := os.OpenFile(StateJournal, os.O_RDWR, 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer journal.Close()
+	if err := syscall.Flock(int(journal.Fd()), syscall.LOCK_EX|syscall.LOCK_NB); !errors.Is(err, syscall.EWOULDBLOCK) {
+		t.Fatalf("locking a loaded journal = %v, want EWOULDBLOCK", err)
+	}
+	resetState()
+	if err := syscall.Flock(int(journal.Fd()), syscall.LOCK_EX|syscall.LOCK_NB); err != nil {
+		t.Errorf("locking a released journal = %v", err)
+	}
+}
@@ -0,0 +1,26 @@
+/*
+Copyright © 2022 NAME HERE <EMAIL ADDRESS>
+
+*/
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"os"
+
+	"golang.org/x/sys/windows"
+)
+
+//lockFile takes an exclusive lock on f, waiting for the process holding it
+//to release it.
+func lockFile(f *os.File) error {
+	handle := windows.Handle(f.Fd())
+	overlapped := &windows.Overlapped{}
+	err := windows.LockFileEx(handle, windows.LOCKFILE_EXCLUSIVE_LOCK|windows.LOCKFILE_FAIL_IMMEDIATELY, 0, 1, 0, overlapped)
+	if !errors.Is(err, windows.ERROR_LOCK_VIOLATION) {
+		return err
+	}
+	fmt.Println("Waiting for another dsync run to finish...")
+	return windows.LockFileEx(handle, windows.LOCKFILE_EXCLUSIVE_LOCK, 0, 1, 0, overlapped)
+}
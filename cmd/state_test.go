@@ -0,0 +1,105 @@
+/*
+Copyright © 2022 NAME HERE <EMAIL ADDRESS>
+
+*/
+package cmd
+
+import (
+	"os"
+	"path"
+	"reflect"
+	"testing"
+)
+
+func TestStateIndex(t *testing.T) {
+	home := testHome(t)
+	root := path.Join(home, "data")
+	inner := path.Join(root, "photos")
+	AddStateTask(root)
+	for _, file := range []string{root, path.Join(root, "a"), path.Join(root, "a/b.txt"), path.Join(root, "c.txt")} {
+		CreateFolderId(file, "drive", "id "+file)
+	}
+	//files without a state for their folder are still found
+	WriteState(path.Join(root, "x/y/z.txt"), "drive", &StateEntry{ID: "z"})
+	WriteState(path.Join(root, "a/b.txt"), "nas", &StateEntry{ID: "b"})
+	AddStateTask(inner)
+	CreateFolderId(inner, "drive", "photos")
+	WriteState(path.Join(inner, "p.jpg"), "drive", &StateEntry{ID: "p"})
+
+	check := func() {
+		t.Helper()
+		st := loadState()
+		want := []string{root, path.Join(root, "a"), path.Join(root, "a/b.txt"), path.Join(root, "c.txt"), inner, path.Join(inner, "p.jpg"), path.Join(root, "x/y/z.txt")}
+		if files := st.under(root, "drive"); !reflect.DeepEqual(files, want) {
+			t.Errorf("under(%q) = %v, want %v", root, files, want)
+		}
+		if files := st.under(path.Join(root, "a"), "drive"); !reflect.DeepEqual(files, want[1:3]) {
+			t.Errorf("under(\"a\") = %v, want %v", files, want[1:3])
+		}
+		if files := st.under(path.Join(root, "x"), "drive"); !reflect.DeepEqual(files, want[6:]) {
+			t.Errorf("under(\"x\") = %v, want %v", files, want[6:])
+		}
+		if files := st.under(path.Join(root, "a"), "nas"); !reflect.DeepEqual(files, want[2:3]) {
+			t.Errorf("under(\"a\") in nas = %v, want %v", files, want[2:3])
+		}
+		children := make(map[string]bool)
+		for _, file := range st.children(root, "drive") {
+			children[file] = true
+		}
+		if want := map[string]bool{want[1]: true, want[3]: true, inner: true}; !reflect.DeepEqual(children, want) {
+			t.Errorf("children(%q) = %v, want %v", root, children, want)
+		}
+	}
+	check()
+	//the state and the index are the same once read again
+	resetState()
+	check()
+
+	MoveState(path.Join(root, "a"), path.Join(root, "moved"), "drive")
+	RemoveState(path.Join(root, "x"), "drive")
+	st := loadState()
+	want := []string{path.Join(root, "moved"), path.Join(root, "moved/b.txt")}
+	if files := st.under(path.Join(root, "moved"), "drive"); !reflect.DeepEqual(files, want) {
+		t.Errorf("under(\"moved\") = %v, want %v", files, want)
+	}
+	for _, gone := range []string{"a", "x", "x/y"} {
+		if files := st.under(path.Join(root, gone), "drive"); len(files) > 0 {
+			t.Errorf("under(%q) = %v after its removal", gone, files)
+		}
+		if _, ok := st.dirs[root]["drive"][path.Dir(gone)][gone]; ok {
+			t.Errorf("%q is still indexed after its removal", gone)
+		}
+	}
+}
+
+func TestStateJournal(t *testing.T) {
+	home := testHome(t)
+	file := path.Join(home, "a.txt")
+	WriteState(file, "drive", &StateEntry{ID: "a"})
+	resetState()
+
+	//a change cut by a crash is dropped, the ones before it are kept
+	journal, err := os.OpenFile(StateJournal, os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	journal.WriteString(`[{"op":"put","task":"` + file + `","remote":"drive","path":".","entry":{"id":"b"}}]` + "\n")
+	journal.WriteString(`[{"op":"put","task":"` + file + `","remote":"nas","path":".","entr`)
+	journal.Close()
+	if entry, err := ReadState(file, "drive"); err != nil || entry.ID != "b" {
+		t.Errorf("state after replay = %+v, %v; want Id b", entry, err)
+	}
+	if _, err := ReadState(file, "nas"); err == nil {
+		t.Error("a torn change was applied")
+	}
+	if journalStats, err := os.Stat(StateJournal); err != nil || journalStats.Size() != 0 {
+		t.Errorf("journal wasn't folded into the state file: %v", err)
+	}
+
+	//changes made after the replay aren't lost
+	WriteState(file, "nas", &StateEntry{ID: "c"})
+	resetState()
+	if entry, err := ReadState(file, "nas"); err != nil || entry.ID != "c" {
+		t.Errorf("state after reload = %+v, %v; want Id c", entry, err)
+	}
+}
@@ -0,0 +1,120 @@
+/*
+Copyright © 2022 NAME HERE <EMAIL ADDRESS>
+
+*/
+package cmd
+
+import (
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"time"
+)
+
+//Symbolic link policies, tasks without a symlink policy follow links.
+const (
+	SymlinkSkip   = "skip"
+	SymlinkFollow = "follow"
+	SymlinkLink   = "link"
+)
+
+//Linker is implemented by backends able to store symbolic links, stored
+//links are listed with their RemoteFile.LinkTarget set.
+type Linker interface {
+	//Symlink creates the link f.Name inside f.Parent, or replaces f.ID when
+	//it's set, pointing to target.
+	Symlink(f *RemoteFile, target string) (*RemoteFile, error)
+}
+
+//ValidSymlinks checks the given symlink policy is supported.
+func ValidSymlinks(policy string) bool {
+	switch policy {
+	case "", SymlinkSkip, SymlinkFollow, SymlinkLink:
+		return true
+	}
+	return false
+}
+
+//linkHash returns the hash kept in the sync state of a symbolic link.
+func linkHash(target string) string {
+	return fmt.Sprintf("%x", sha256.Sum256([]byte("symlink:"+target)))
+}
+
+//enterDir marks the real path of dir as being synced. It returns false if
+//it already is, following a symbolic link to it would never end.
+func (s *Syncer) enterDir(dir string) bool {
+	realDir, err := filepath.EvalSymlinks(dir)
+	if err != nil {
+		log.Fatalf("Unable to resolve dir %q: %v", dir, err)
+	}
+	if s.syncingDirs[realDir] {
+		return false
+	}
+	s.syncingDirs[realDir] = true
+	return true
+}
+
+//leaveDir marks the real path of dir as synced.
+func (s *Syncer) leaveDir(dir string) {
+	if realDir, err := filepath.EvalSymlinks(dir); err == nil {
+		delete(s.syncingDirs, realDir)
+	}
+}
+
+//SyncLink sync/backup a symbolic link as a link to the syncer remote, the
+//file it points to isn't synced.
+func (s *Syncer) SyncLink(file string, parent string) {
+	target, err := os.Readlink(file)
+	if err != nil {
+		log.Fatalf("Unable to read symbolic link %q: %v", file, err)
+	}
+	hash := linkHash(target)
+	if !s.DryRun {
+		s.healFile(file, parent)
+	}
+	syncedHash, linkId, _, err := ReadChkSum(file, s.Remote)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		log.Fatalf("Unable to read sync state of %q: %v", file, err)
+	}
+	if err == nil && syncedHash == hash {
+		fmt.Printf("Symbolic link %q is backed up and hasn't been modified in %v\n", file, s.Remote)
+		return
+	}
+	if s.DryRun {
+		fmt.Printf("Would upload symbolic link %q to %v\n", file, s.Remote)
+		s.plan.links++
+		return
+	}
+	linker, ok := s.Backend.(Linker)
+	if !ok {
+		fmt.Printf("Remote %v can't store symbolic links, %q was skipped\n", s.Remote, file)
+		return
+	}
+	remoteLink, err := linker.Symlink(&RemoteFile{
+		ID:     linkId,
+		Name:   filepath.Base(file),
+		Parent: parent,
+	}, target)
+	if err != nil {
+		log.Fatalf("Unable to upload symbolic link %q to %v: %v", file, s.Remote, err)
+	}
+	fmt.Printf("Uploaded symbolic link %q Id %v to %v\n", file, remoteLink.ID, s.Remote)
+	writeChkSum(file, s.Remote, hash, remoteLink)
+}
+
+//createLink replaces file with a symbolic link to target.
+func createLink(file, target string) error {
+	tmp := path.Join(path.Dir(file), fmt.Sprintf(".dsync-%d", time.Now().UnixNano()))
+	if err := os.Symlink(target, tmp); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, file); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return nil
+}
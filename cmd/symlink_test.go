@@ -0,0 +1,90 @@
+/*
+Copyright © 2022 NAME HERE <EMAIL ADDRESS>
+
+*/
+package cmd
+
+import (
+	"os"
+	"path"
+	"reflect"
+	"testing"
+)
+
+//linkedTree writes a task folder holding symbolic links to a file, to a
+//folder outside of it, to itself and to nothing.
+func linkedTree(t *testing.T) string {
+	t.Helper()
+	dir := t.TempDir()
+	src := path.Join(dir, "src")
+	writeFile(t, path.Join(src, "a.txt"), "a")
+	writeFile(t, path.Join(dir, "ext", "e.txt"), "e")
+	for name, target := range map[string]string{"link.txt": "a.txt", "ext": "../ext", "loop": ".", "broken": "missing.txt"} {
+		if err := os.Symlink(target, path.Join(src, name)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return src
+}
+
+func TestSymlinkPolicies(t *testing.T) {
+	testHome(t)
+	src := linkedTree(t)
+	for _, test := range []struct {
+		policy string
+		want   map[string]string
+	}{
+		{SymlinkSkip, map[string]string{"a.txt": "a"}},
+		//the link to the task itself is a loop and isn't followed
+		{SymlinkFollow, map[string]string{"a.txt": "a", "link.txt": "a", "ext/": "", "ext/e.txt": "e"}},
+		{SymlinkLink, map[string]string{
+			"a.txt": "a", "link.txt": "-> a.txt", "ext": "-> ../ext", "loop": "-> .", "broken": "-> missing.txt",
+		}},
+	} {
+		remoteDir := t.TempDir()
+		syncer := &Syncer{Remote: test.policy, Backend: NewLocalBackend(remoteDir), Symlinks: test.policy}
+		syncer.Sync(src)
+		if tree := localTree(t, path.Join(remoteDir, "src")); !reflect.DeepEqual(tree, test.want) {
+			t.Errorf("%v: remote tree = %v, want %v", test.policy, tree, test.want)
+		}
+	}
+}
+
+func TestRestoreSymlinks(t *testing.T) {
+	testHome(t)
+	src := linkedTree(t)
+	remoteDir := t.TempDir()
+	backend := NewLocalBackend(remoteDir)
+	(&Syncer{Remote: "local", Backend: backend, Symlinks: SymlinkLink}).Sync(src)
+	folderId, _ := ReadFolderId(src, "local")
+	folder, err := backend.Stat(folderId)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	//into another directory
+	to := path.Join(t.TempDir(), "src")
+	(&Restorer{Remote: "local", Backend: backend}).RestoreDir(folder, to, src)
+	want := map[string]string{
+		"a.txt": "a", "link.txt": "-> a.txt", "ext": "-> ../ext", "loop": "-> .", "broken": "-> missing.txt",
+	}
+	if tree := localTree(t, to); !reflect.DeepEqual(tree, want) {
+		t.Errorf("restored tree = %v, want %v", tree, want)
+	}
+
+	//in place, the restored links are synced already
+	for _, name := range []string{"link.txt", "ext"} {
+		if err := os.Remove(path.Join(src, name)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	(&Restorer{Remote: "local", Backend: backend, InPlace: true}).RestoreDir(folder, src, src)
+	if tree := localTree(t, src); !reflect.DeepEqual(tree, want) {
+		t.Errorf("tree restored in place = %v, want %v", tree, want)
+	}
+	for _, name := range []string{"link.txt", "ext"} {
+		if hash, _, _, err := ReadChkSum(path.Join(src, name), "local"); err != nil || hash != linkHash(want[name][3:]) {
+			t.Errorf("sync state of restored link %q = %v, %v", name, hash, err)
+		}
+	}
+}
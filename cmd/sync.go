@@ -10,6 +10,8 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
+	"io/fs"
 	"io/ioutil"
 	"log"
 	"net/http"
@@ -17,27 +19,46 @@ import (
 	"os/exec"
 	"path"
 	"path/filepath"
-	"regexp"
+	"strings"
 	"time"
 
 	"github.com/spf13/cobra"
 	"golang.org/x/oauth2"
 	"golang.org/x/oauth2/google"
 	"google.golang.org/api/drive/v3"
+	"google.golang.org/api/option"
 )
 
 var UserHome, _ = os.UserHomeDir()
 
+//driveTokenFile returns the file keeping the Drive token, tokens with
+//access to the whole drive are kept apart from the ones limited to the
+//files created by dsync.
+func driveTokenFile(fullAccess bool) string {
+	if fullAccess {
+		return path.Join(UserHome, ".dsync/token-full.json")
+	}
+	return path.Join(UserHome, ".dsync/token.json")
+}
+
+//driveScope returns the OAuth scope of the Drive tokens, the files created
+//by dsync or the whole drive.
+func driveScope(fullAccess bool) string {
+	if fullAccess {
+		return drive.DriveScope
+	}
+	return drive.DriveFileScope
+}
+
 // Retrieve a token, saves the token, then returns the generated client.
-func getClient(config *oauth2.Config) *http.Client {
+func getClient(config *oauth2.Config, tokFile string) *http.Client {
 	// The file token.json stores the user's access and refresh tokens, and is
 	// created automatically when the authorization flow completes for the first
 	// time.
-	//tokFile := filepath.Join(UserHome, ".dsync/token.json")
-	tok, err := tokenFromFile(config)
+	tok, err := tokenFromFile(config, tokFile)
 	if err != nil {
 		tok = getTokenFromWeb(config)
-		saveToken(tok)
+		saveToken(tokFile, tok)
 	}
 	return config.Client(context.Background(), tok)
 }
@@ -70,8 +91,8 @@ func getTokenFromWeb(config *oauth2.Config) *oauth2.Token {
 }
 
 // Retrieves a token from a local file.
-func tokenFromFile(config *oauth2.Config) (*oauth2.Token, error) {
-	f, err := os.Open(path.Join(UserHome, ".dsync/token.json"))
+func tokenFromFile(config *oauth2.Config, tokFile string) (*oauth2.Token, error) {
+	f, err := os.Open(tokFile)
 	if err != nil {
 		return nil, err
 	}
@@ -95,12 +116,12 @@ func tokenFromFile(config *oauth2.Config) (*oauth2.Token, error) {
 }
 
 // Saves a token to a file path.
-func saveToken(token *oauth2.Token) {
+func saveToken(tokFile string, token *oauth2.Token) {
 	if err := os.Mkdir(path.Join(UserHome, ".dsync"), 0750); err != nil && !os.IsExist(err) {
 		log.Fatalf("Could'n create '.dsync' folder: %v", err)
 	}
 	fmt.Printf("Saving credential file to: %s\n", path.Join(UserHome, ".dsync"))
-	f, err := os.OpenFile(path.Join(UserHome, ".dsync/token.json"), os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0600)
+	f, err := os.OpenFile(tokFile, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0600)
 	if err != nil {
 		log.Fatalf("Unable to cache oauth token: %v", err)
 	}
@@ -108,69 +129,238 @@ func saveToken(token *oauth2.Token) {
 	json.NewEncoder(f).Encode(token)
 }
 
-//SyncDir sync/backup a folder recurrently to google drive.
-func SyncDir(dir string, parent []string, srv *drive.Service) {
+//Syncer sync/backup files and folders to a remote.
+type Syncer struct {
+	//Remote is the remote name, every remote keeps its own sync state.
+	Remote  string
+	Backend Backend
+	//Mirror is the policy applied to remote files whose local source is
+	//gone, remote files are never removed when it's empty.
+	Mirror string
+	//TwoWay downloads the changes made to remote files before syncing,
+	//backends have to implement Changer.
+	TwoWay bool
+	//Conflict is the policy applied to files modified both locally and in
+	//the remote since their last sync, see ConflictKeepBoth.
+	Conflict string
+	//Exclude and Include are gitignore patterns of the files and folders
+	//that aren't synced and that are synced anyway, they are applied
+	//after the ignore files.
+	Exclude []string
+	Include []string
+	//DryRun prints what would be synced without calling the remote or
+	//writing the sync state.
+	DryRun bool
+	//Symlinks is the policy applied to symbolic links, see SymlinkFollow.
+	Symlinks string
+	//Snapshot takes a dated snapshot of the task instead of updating its
+	//remote copy in place, see Manifest.
+	Snapshot bool
+	//RemotePath is the path of the remote folder the task is synced
+	//under, e.g. "Backups/laptop", the remote root when it's empty.
+	RemotePath string
+
+	//Id of the remote folder at RemotePath
+	root string
+
+	//state of files and folders whose local source is gone, by content
+	//hash and by remote Id, used to detect renamed and moved files
+	orphanFiles   map[string][]orphan
+	orphanFolders map[string]orphan
+	//folders to mirror once the whole task is synced
+	mirrorDirs []pendingMirror
+	//copies of the files in conflict saved by the keep-both policy that
+	//their folder doesn't list yet
+	conflictCopies []string
+	//what a dry run would do
+	plan syncPlan
+	//files and folders of the task that aren't synced
+	ignore *Ignorer
+	//real paths of the folders being synced, to detect symbolic link loops
+	syncingDirs map[string]bool
+	//snapshot being taken
+	snap *snapshotState
+	//Ids of the remote children of the folders being synced, to detect
+	//deleted and trashed remote files
+	remoteChildren map[string]map[string]bool
+}
 
-	driveFolderName := filepath.Base(dir)
-	dirDsyncFile, err := os.Open(path.Dir(dir) + "/." + driveFolderName + ".dsync")
-	var driveFolderId []string
-	if errors.Is(err, os.ErrNotExist) {
-		folderMeta := &drive.File{
-			Name:     driveFolderName,
-			MimeType: "application/vnd.google-apps.folder",
-			Parents:  parent,
-		}
-		driveFolder, err := srv.Files.Create(folderMeta).Do()
+//Sync sync/backup a task file or folder to the syncer remote folder at
+//RemotePath.
+func (s *Syncer) Sync(file string) {
+	s.ignore = NewIgnorer(file, s.Exclude, s.Include)
+	s.syncingDirs = make(map[string]bool)
+	s.remoteChildren = make(map[string]map[string]bool)
+	//dry runs are planned from the sync state only, without calling the
+	//remote
+	if !s.DryRun {
+		root, err := ResolveRemotePath(s.Backend, s.RemotePath, true)
 		if err != nil {
-			log.Fatalf("Unable to create Drive folder: %v", err)
+			log.Fatalf("Unable to get remote folder %q in %v: %v", s.RemotePath, s.Remote, err)
 		}
-		driveFolderId = append(driveFolderId, driveFolder.Id)
-		dirDsyncFile, err := os.Create(path.Dir(dir) + "/." + driveFolderName + ".dsync")
-		if err != nil {
-			log.Fatalf("Unable to create file %q: %v\n", path.Dir(dir)+"/."+driveFolderName+".dsync", err)
+		s.root = root
+	}
+	if s.Snapshot {
+		s.snapshot(file)
+		return
+	}
+	//the state files of older versions are imported before anything is
+	//synced, or every file would be uploaded again
+	ImportLegacyState(file, s.DryRun)
+	if !s.DryRun {
+		AddStateTask(file)
+	}
+	var changesToken string
+	if s.TwoWay && !s.DryRun {
+		changesToken = s.pull(file)
+	}
+	fileStats, err := os.Lstat(file)
+	if err != nil {
+		log.Fatalf("Unable to get file or dir %q stats: %v", file, err)
+	}
+	isLink := fileStats.Mode()&fs.ModeSymlink != 0
+	if isLink && (s.Symlinks == "" || s.Symlinks == SymlinkFollow) {
+		if fileStats, err = os.Stat(file); err != nil {
+			log.Fatalf("Unable to follow symbolic link %q: %v", file, err)
 		}
+		isLink = false
+	}
+
+	switch {
+	case isLink && s.Symlinks == SymlinkSkip:
+		fmt.Printf("Symbolic link %q was skipped\n", file)
+
+	case isLink:
+		s.SyncLink(file, s.root)
 
-		if _, err := dirDsyncFile.WriteString(driveFolder.Id); err != nil {
-			log.Fatalf("Unable to write to %q: %v", path.Dir(dir)+"/."+driveFolderName+".dsync", err)
+	case fileStats.Mode().IsDir():
+		s.scanOrphans(file)
+		s.SyncDir(file, s.root)
+
+	case fileStats.Mode().IsRegular():
+		s.SyncFile(file, s.root)
+	}
+
+	//mirror once every renamed or moved file has been moved
+	if len(s.mirrorDirs) > 0 {
+		elsewhere := s.syncedElsewhere(file)
+		for _, m := range s.mirrorDirs {
+			s.mirrorDir(m.dir, m.folderId, m.keep, elsewhere)
 		}
-	} else {
-		b := make([]byte, 16)
-		var byteSlc []byte
-		for {
-			n, err := dirDsyncFile.Read(b)
-			if n != 0 {
-				byteSlc = append(byteSlc, b[:n]...)
-			}
-			if err != nil {
-				break
-			}
+	}
+	s.mirrorDirs = nil
+	if changesToken != "" {
+		SaveChangesToken(file, s.Remote, changesToken)
+	}
+	if s.DryRun {
+		s.printPlan(file)
+	}
+}
+
+//SyncDir sync/backup a folder recurrently to the syncer remote.
+func (s *Syncer) SyncDir(dir string, parent string) {
+	if !s.enterDir(dir) {
+		fmt.Printf("Folder %q links to a folder being synced and was skipped to avoid a loop\n", dir)
+		return
+	}
+	defer s.leaveDir(dir)
+
+	driveFolderId, err := ReadFolderId(dir, s.Remote)
+	if err == nil && !s.DryRun {
+		//the remote folder may have been deleted or trashed
+		driveFolderId = s.healFolder(dir, driveFolderId)
+	}
+	if errors.Is(err, os.ErrNotExist) && !s.DryRun {
+		//a renamed or moved folder keeps its remote folder
+		driveFolderId, err = s.moveDir(dir, parent), nil
+	}
+	if errors.Is(err, os.ErrNotExist) && s.DryRun {
+		fmt.Printf("Would create folder %q in %v\n", dir, s.Remote)
+		s.plan.folders++
+		err = nil
+	}
+	if err != nil {
+		log.Fatalf("Unable to get remote folder Id: %v", err)
+	}
+	if driveFolderId == "" && !s.DryRun {
+		driveFolder, err := s.Backend.CreateFolder(&RemoteFile{
+			Name:   filepath.Base(dir),
+			Parent: parent,
+		})
+		if err != nil {
+			log.Fatalf("Unable to create remote folder: %v", err)
 		}
-		driveFolderId = append(driveFolderId, string(byteSlc))
+		driveFolderId = driveFolder.ID
+		CreateFolderId(dir, s.Remote, driveFolderId)
 	}
-	defer dirDsyncFile.Close()
 
 	currentDirFiles, err := os.ReadDir(dir)
 	if err != nil {
 		log.Fatalf("Unable to read dir: %v", err)
 	}
 
-	reg := regexp.MustCompile(`^\..+|.+~$`)
+	//remote Ids of the synced files and folders, kept in mirror mode
+	keep := make(map[string]bool)
 	for _, file := range currentDirFiles {
-		if reg.MatchString(file.Name()) {
+		filePath := path.Join(dir, file.Name())
+		fileType := file.Type()
+		if fileType&fs.ModeSymlink != 0 && (s.Symlinks == "" || s.Symlinks == SymlinkFollow) {
+			fileStats, err := os.Stat(filePath)
+			if err != nil {
+				fmt.Printf("Symbolic link %q is broken and was skipped\n", filePath)
+				continue
+			}
+			fileType = fileStats.Mode().Type()
+		}
+		if s.ignore.Ignored(filePath, fileType.IsDir()) {
 			continue
 		}
-		if file.IsDir() {
-			SyncDir(path.Join(dir, file.Name()), driveFolderId, srv)
-		} else {
-			SyncFile(path.Join(dir, file.Name()), driveFolderId, srv)
+		switch {
+		case fileType.IsDir():
+			s.SyncDir(filePath, driveFolderId)
+			if folderId, err := ReadFolderId(filePath, s.Remote); err == nil {
+				keep[folderId] = true
+			}
+			continue
+		case fileType.IsRegular():
+			s.SyncFile(filePath, driveFolderId)
+			//kept in mirror mode like the files listed
+			for _, copyFile := range s.conflictCopies {
+				if _, fileId, _, err := ReadChkSum(copyFile, s.Remote); err == nil {
+					keep[fileId] = true
+				}
+			}
+			s.conflictCopies = nil
+		case fileType&fs.ModeSymlink != 0 && s.Symlinks == SymlinkLink:
+			s.SyncLink(filePath, driveFolderId)
+		case fileType&fs.ModeSymlink != 0:
+			fmt.Printf("Symbolic link %q was skipped\n", filePath)
+			continue
+		default:
+			fmt.Printf("%q isn't a regular file and was skipped\n", filePath)
+			continue
+		}
+		if _, fileId, _, err := ReadChkSum(filePath, s.Remote); err == nil {
+			keep[fileId] = true
 		}
 	}
+	delete(s.remoteChildren, driveFolderId)
+	if s.Mirror != "" && !s.DryRun {
+		s.mirrorDirs = append(s.mirrorDirs, pendingMirror{dir: dir, folderId: driveFolderId, keep: keep})
+	}
 }
 
-//SyncFile sync/backup a file to Google Drive.
-func SyncFile(file string, parent []string, srv *drive.Service) {
-	if ChkSumFile(file) {
-		fmt.Printf("File %q is backed up and hasn't been modified\n", file)
+//SyncFile sync/backup a file to the syncer remote.
+func (s *Syncer) SyncFile(file string, parent string) {
+	if !s.DryRun {
+		s.healFile(file, parent)
+	}
+	if ChkSumFile(file, s.Remote) {
+		fmt.Printf("File %q is backed up and hasn't been modified in %v\n", file, s.Remote)
+		if s.DryRun {
+			s.plan.unchanged++
+			s.plan.unchangedBytes += fileSize(file)
+		}
 		return
 	}
 
@@ -181,119 +371,156 @@ func SyncFile(file string, parent []string, srv *drive.Service) {
 	defer f.Close()
 
 	fileName := filepath.Base(file)
-	fileDir := path.Dir(file)
-	chkSumFile, err := os.Open(path.Join(fileDir + "/." + fileName + ".sha256sum"))
+	_, driveFileId, revision, err := ReadChkSum(file, s.Remote)
 
+	if errors.Is(err, os.ErrNotExist) && s.moveFile(file, parent) {
+		return
+	}
+	if errors.Is(err, os.ErrNotExist) && s.DryRun {
+		size := fileSize(file)
+		fmt.Printf("Would upload file %q (%v) to %v\n", file, formatSize(size), s.Remote)
+		s.plan.uploads++
+		s.plan.uploadBytes += size
+		return
+	}
 	if errors.Is(err, os.ErrNotExist) {
-		fileMeta := &drive.File{
-			Name:    fileName,
-			Parents: parent,
-		}
-		driveFile, err := srv.Files.Create(fileMeta).Media(f).Do()
+		meta := localMeta(file)
+		meta.Name, meta.Parent = fileName, parent
+		driveFile, err := s.Backend.Upload(meta, f)
 		if err != nil {
-			log.Fatalf("Unable to create file %q in Google Drive: %v", fileName, err)
+			log.Fatalf("Unable to create file %q in %v: %v", fileName, s.Remote, err)
 		}
-		fmt.Printf("Uploaded file %q Id %v to Google Drive\n", file, driveFile.Id)
-		CreateChkSum(file, driveFile.Id)
+		fmt.Printf("Uploaded file %q Id %v to %v\n", file, driveFile.ID, s.Remote)
+		CreateChkSum(file, s.Remote, driveFile)
 		return
 	}
-
-	if _, err := chkSumFile.Seek(65, 0); err != nil {
-		log.Fatalf("Unable to get drive file Id: %v\n", err)
+	if err != nil {
+		log.Fatalf("Unable to get remote file Id: %v\n", err)
 	}
-	byteSlc := make([]byte, 16)
-	var driveFileId []byte
-	for {
-		n, err := chkSumFile.Read(byteSlc)
-		if n != 0 {
-			driveFileId = append(driveFileId, byteSlc[:n]...)
-		}
-		if err != nil {
-			break
-		}
+	if s.DryRun {
+		size := fileSize(file)
+		fmt.Printf("Would update file %q (%v) in %v\n", file, formatSize(size), s.Remote)
+		s.plan.updates++
+		s.plan.updateBytes += size
+		return
 	}
-	driveFile, err := srv.Files.Update(string(driveFileId), &drive.File{}).Media(f).Do()
+	if s.resolveConflict(file, parent, driveFileId, revision) {
+		return
+	}
+
+	meta := localMeta(file)
+	meta.ID = driveFileId
+	driveFile, err := s.Backend.Update(meta, f)
 	if err != nil {
-		log.Fatalf("Unable to update file %q in Google Drive: %v", fileName, err)
+		log.Fatalf("Unable to update file %q in %v: %v", fileName, s.Remote, err)
 	}
 
-	fmt.Printf("Updated file %q Id %v in Google Drive\n", file, driveFile.Id)
-	CreateChkSum(file, driveFile.Id)
+	fmt.Printf("Updated file %q Id %v in %v\n", file, driveFile.ID, s.Remote)
+	CreateChkSum(file, s.Remote, driveFile)
 }
 
-//ChkSumFile check if the given file hasn't been modified or backed up.
-func ChkSumFile(file string) bool {
-	fileDir := path.Dir(file)
-	fileName := filepath.Base(file)
-	chkSumFile := path.Join(fileDir, "/."+fileName+".sha256sum")
-	chkSumFileHandle, err := os.Open(chkSumFile)
-	defer chkSumFileHandle.Close()
-	if errors.Is(err, os.ErrNotExist) {
-		return false
+//ReadFolderId returns the remote folder Id of the given dir.
+func ReadFolderId(dir, remote string) (string, error) {
+	entry, err := ReadState(dir, remote)
+	if err != nil {
+		return "", err
+	}
+	if !entry.IsDir {
+		return "", fmt.Errorf("%q was synced as a file: %w", dir, os.ErrNotExist)
 	}
+	return entry.ID, nil
+}
 
-	checksumHash := make([]byte, 64)
-	if _, err := chkSumFileHandle.Read(checksumHash); err != nil {
-		log.Fatalf("Unable to read checksum file hash: %v\n", err)
+//CreateFolderId stores the remote folder Id of the given dir.
+func CreateFolderId(dir, remote, folderId string) {
+	entry := &StateEntry{IsDir: true, ID: folderId}
+	if dirStats, err := os.Stat(dir); err == nil {
+		entry.ModTime = dirStats.ModTime()
 	}
-	//fmt.Printf("Readed %v bytes from file %v\n", n, chkSumFile)
-	fileData, err := os.ReadFile(file)
+	WriteState(dir, remote, entry)
+}
+
+//ReadChkSum returns the hash, the remote file Id and the remote revision
+//stored in the sync state of the given file.
+func ReadChkSum(file, remote string) (hash, id, revision string, err error) {
+	entry, err := ReadState(file, remote)
 	if err != nil {
-		log.Fatalf("Unable to read file %q: %v\n", file, err)
+		return "", "", "", err
 	}
-	fileHash := sha256.New()
-	if _, err := fileHash.Write(fileData); err != nil {
-		log.Fatalf("Unable to write data: %v\n", err)
+	if entry.IsDir {
+		return "", "", "", fmt.Errorf("%q was synced as a folder: %w", file, os.ErrNotExist)
 	}
-	//fmt.Printf("Hashing %v bytes from %v\n", hashBytes, file)
-	hashSlc := fmt.Sprintf("%x", fileHash.Sum(nil))
-	if string(checksumHash) == hashSlc {
-		return true
-	}
-	return false
+	return entry.Hash, entry.ID, entry.Revision, nil
 }
 
-//CreateChkSum create checksum file from given file.
-func CreateChkSum(file, driveFileId string) {
-	fileDir := path.Dir(file)
-	fileName := filepath.Base(file)
-	chkSumFileHandle, err := os.Create(fileDir + "/." + fileName + ".sha256sum")
+//ChkSumFile check if the given file hasn't been modified or backed up.
+func ChkSumFile(file, remote string) bool {
+	checksumHash, _, _, err := ReadChkSum(file, remote)
+	if errors.Is(err, os.ErrNotExist) {
+		return false
+	}
 	if err != nil {
-		log.Fatalf("Unable to create sha256sum file: %v\n", err)
+		log.Fatalf("Unable to read sync state: %v\n", err)
 	}
-	defer chkSumFileHandle.Close()
-	fileData, err := os.ReadFile(file)
+	return checksumHash == HashFile(file)
+}
+
+//HashFile returns the hex encoded sha256 hash of the given file content.
+func HashFile(file string) string {
+	f, err := os.Open(file)
 	if err != nil {
 		log.Fatalf("Unable to read file %q: %v\n", file, err)
 	}
+	defer f.Close()
 	fileHash := sha256.New()
-	if _, err := fileHash.Write(fileData); err != nil {
-		log.Fatalf("Unable to write data to hash: %v\n", err)
+	if _, err := io.Copy(fileHash, f); err != nil {
+		log.Fatalf("Unable to read file %q: %v\n", file, err)
 	}
+	return fmt.Sprintf("%x", fileHash.Sum(nil))
+}
 
-	if _, err := fmt.Fprintf(chkSumFileHandle, "%x %s", fileHash.Sum(nil), driveFileId); err != nil {
-		log.Fatalf("Unable to write data to checksum file: %v\n", err)
-	}
-	//fmt.Printf("Writed %v bytes to checksum file\n", chkSumBytes)
+//CreateChkSum stores the hash of the given file and its remote copy in the
+//sync state.
+func CreateChkSum(file, remote string, remoteFile *RemoteFile) {
+	writeChkSum(file, remote, HashFile(file), remoteFile)
+}
 
+//writeChkSum stores the sync state of the given file with a known hash.
+func writeChkSum(file, remote, hash string, remoteFile *RemoteFile) {
+	entry := &StateEntry{Hash: hash, ID: remoteFile.ID, Revision: remoteFile.Revision}
+	if fileStats, err := os.Lstat(file); err == nil {
+		entry.Size, entry.ModTime = fileStats.Size(), fileStats.ModTime()
+	}
+	WriteState(file, remote, entry)
 }
 
-//GetGoogleService return a Google Drive service handler.
-func GetDriveService() *drive.Service {
-	//using configuration json file while in development
-	b, err := ioutil.ReadFile(filepath.Join(UserHome, ".dsync_dev/client_secret_654016737032-d7mq9oms5vjt5048ehhsh9rauuvjcms8.apps.googleusercontent.com.json"))
-	if err != nil {
-		log.Fatalf("Unable to read client secret file: %v", err)
+//GetDriveService return a Google Drive service handler for the given remote.
+//Without fullAccess it's only authorized to use the files created by dsync,
+//shared drives and the changes made by other apps are out of its reach.
+func GetDriveService(remote *Remote, fullAccess bool) *drive.Service {
+	var opts []option.ClientOption
+	if remote.Endpoint != "" {
+		//used to point dsync to a fake Drive server while testing
+		opts = append(opts, option.WithEndpoint(strings.TrimSuffix(remote.Endpoint, "/")+"/drive/v3/"))
 	}
+	if remote.NoAuth {
+		opts = append(opts, option.WithoutAuthentication())
+	} else {
+		//using configuration json file while in development
+		b, err := ioutil.ReadFile(filepath.Join(UserHome, ".dsync_dev/client_secret_654016737032-d7mq9oms5vjt5048ehhsh9rauuvjcms8.apps.googleusercontent.com.json"))
+		if err != nil {
+			log.Fatalf("Unable to read client secret file: %v", err)
+		}
 
-	//If modifying these scopes, delete your previously saved token.json.
-	config, err := google.ConfigFromJSON(b, drive.DriveFileScope)
-	if err != nil {
-		log.Fatalf("Unable to parse client secret file to config: %v", err)
+		//If modifying these scopes, delete your previously saved token.json.
+		config, err := google.ConfigFromJSON(b, driveScope(fullAccess))
+		if err != nil {
+			log.Fatalf("Unable to parse client secret file to config: %v", err)
+		}
+		opts = append(opts, option.WithHTTPClient(getClient(config, driveTokenFile(fullAccess))))
 	}
-	client := getClient(config)
 
-	srv, err := drive.New(client)
+	srv, err := drive.NewService(context.Background(), opts...)
 	if err != nil {
 		log.Fatalf("Unable to retrieve Drive client: %v", err)
 	}
@@ -305,33 +532,81 @@ var syncCmd = &cobra.Command{
 	Use:   "sync [file|dir]",
 	Short: "Sync a file or a directory",
 	Long: `Sync/backup a file or a directory:
-"dsync sync [file|dir]"
-If a directory is specified it will be synced recurrently.`,
+"dsync sync [file|dir] [--dest remote...] [--mirror trash|delete] [--two-way]
+[--conflict keep-both|local|remote|skip] [--snapshot] [--shared-drive id|name] [--remote path]
+[--exclude pattern...] [--include pattern...] [--symlinks skip|follow|link] [--dry-run]"
+If a directory is specified it will be synced recurrently.
+The sync state of the files and folders is kept in "~/.dsync/state.json",
+nothing is written next to them. Older versions kept it in hidden files
+next to them, those are imported by the first sync of a task without a
+sync state, see "dsync migrate-state".
+Remote files and folders deleted since their last sync are uploaded again,
+trashed ones are restored from the trash.
+Dotfiles, editor backups and the files matching the gitignore patterns of
+the ".dsyncignore" files and of "--exclude" aren't synced, "--include"
+patterns and "!pattern" lines sync them anyway.
+Symbolic links are followed, skipped or uploaded as links following the
+"--symlinks" policy.
+With "--mirror" remote files and folders whose local source is gone
+are moved to the remote trash or deleted, ignored files and the files of
+other tasks are kept.
+With "--two-way" remote changes made since the last two-way sync are
+downloaded first, drive remotes ask for access to the whole drive.
+With "--conflict" files modified in the remote since their last sync are
+kept both, overwritten, downloaded or skipped.
+With "--snapshot" a dated snapshot is taken, only the files modified since
+the last snapshot are uploaded.
+With "--shared-drive" the file or directory is stored in the given shared
+drive, all the remotes have to be drive remotes with access to the whole
+drive.
+With "--remote" the file or directory is synced under the given remote
+folder path, e.g. "Backups/laptop", missing folders are created.
+With "--dry-run" the files and folders that would be synced are printed
+with byte totals, planned from the sync state only: the remote isn't
+called at all. Remote changes, conflicts and mirror removals can't be
+known without calling the remote and aren't printed, with "--snapshot"
+every file is counted as an upload.`,
 	Args: cobra.ExactArgs(1),
 	Run: func(cmd *cobra.Command, args []string) {
 
 		//Time benchmarking
 		startTime := time.Now()
-		defer fmt.Printf("Time enlapsed: %v\n", time.Since(startTime))
+		defer func() {
+			fmt.Printf("Time enlapsed: %v\n", time.Since(startTime))
+		}()
 
 		fileToSync, err := filepath.Abs(args[0])
 		if err != nil {
 			log.Fatalf("Unable to get file or directory %q: %v", args[0], err)
 		}
-		fileStats, err := os.Lstat(fileToSync)
-		if err != nil {
-			log.Fatalf("Unable to get file or dir %q stats: %v", args[0], err)
+		task := Task{Path: fileToSync}
+		task.Dests, _ = cmd.Flags().GetStringSlice("dest")
+		task.Mirror, _ = cmd.Flags().GetString("mirror")
+		task.TwoWay, _ = cmd.Flags().GetBool("two-way")
+		task.Conflict, _ = cmd.Flags().GetString("conflict")
+		task.Exclude, _ = cmd.Flags().GetStringSlice("exclude")
+		task.Include, _ = cmd.Flags().GetStringSlice("include")
+		task.Symlinks, _ = cmd.Flags().GetString("symlinks")
+		task.Snapshot, _ = cmd.Flags().GetBool("snapshot")
+		task.SharedDrive, _ = cmd.Flags().GetString("shared-drive")
+		remotePath, _ := cmd.Flags().GetString("remote")
+		if task.RemotePath, err = CleanRemotePath(remotePath); err != nil {
+			log.Fatalf("Unable to sync %q: %v", fileToSync, err)
 		}
-
-		srv := GetDriveService()
-
-		switch {
-		case fileStats.Mode().IsDir():
-			SyncDir(fileToSync, nil, srv)
-
-		case fileStats.Mode().IsRegular():
-			SyncFile(fileToSync, nil, srv)
+		if !ValidSymlinks(task.Symlinks) {
+			log.Fatalf("Unknown symlink policy %q", task.Symlinks)
+		}
+		if !ValidConflict(task.Conflict) {
+			log.Fatalf("Unknown conflict policy %q", task.Conflict)
+		}
+		if !ValidMirror(task.Mirror) {
+			log.Fatalf("Unknown mirror policy %q", task.Mirror)
+		}
+		if task.Snapshot && (task.Mirror != "" || task.TwoWay || task.Conflict != "") {
+			log.Fatalf("Snapshots can't be taken with mirror, two-way or conflict policies")
 		}
+		dryRun, _ := cmd.Flags().GetBool("dry-run")
+		RunTask(task, make(map[string]Backend), dryRun)
 
 	},
 }
@@ -347,4 +622,15 @@ func init() {
 
 	// Cobra supports local flags which will only run when this command
 	// is called directly, e.g.:
+	syncCmd.Flags().StringSliceP("dest", "d", nil, "Remotes to sync to, can be repeated (default [drive])")
+	syncCmd.Flags().StringP("mirror", "m", "", "Remove remote files whose local source is gone: trash|delete")
+	syncCmd.Flags().StringSliceP("exclude", "e", nil, "Gitignore pattern of files and folders not to sync, can be repeated")
+	syncCmd.Flags().StringSliceP("include", "i", nil, "Gitignore pattern of files and folders to sync anyway, can be repeated")
+	syncCmd.Flags().StringP("symlinks", "l", "", "Symbolic links policy: skip|follow|link (default follow)")
+	syncCmd.Flags().StringP("conflict", "c", "", "Files modified both locally and remotely: keep-both|local|remote|skip (default keep-both)")
+	syncCmd.Flags().BoolP("dry-run", "n", false, "Print what would be synced without syncing it")
+	syncCmd.Flags().Bool("two-way", false, "Download remote changes before syncing, drive remotes only")
+	syncCmd.Flags().Bool("snapshot", false, "Take a dated snapshot instead of updating the remote copy")
+	syncCmd.Flags().String("shared-drive", "", "Id or name of the shared drive to sync to, drive remotes only")
+	syncCmd.Flags().String("remote", "", "Remote folder path to sync under (default the remote root)")
 }
@@ -0,0 +1,334 @@
+/*
+Copyright © 2022 NAME HERE <EMAIL ADDRESS>
+
+*/
+package cmd
+
+import (
+	"errors"
+	"os"
+	"path"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/adrianburgoscolas/dsync/internal/fakedrive"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	"google.golang.org/api/drive/v3"
+)
+
+//startFakeDrive starts a fake Drive server and adds it as the default
+//remote.
+func startFakeDrive(t *testing.T) *fakedrive.Server {
+	t.Helper()
+	s := fakedrive.NewServer()
+	t.Cleanup(s.Close)
+	runDsync(t, "remote", "add", DefaultRemote, "--type", "drive", "--endpoint", s.URL, "--no-auth")
+	return s
+}
+
+//runDsync runs the dsync command line with the given arguments, flags not
+//given have their default value.
+func runDsync(t *testing.T, args ...string) {
+	t.Helper()
+	resetFlags(rootCmd)
+	rootCmd.SetArgs(args)
+	if err := rootCmd.Execute(); err != nil {
+		t.Fatalf("dsync %v: %v", strings.Join(args, " "), err)
+	}
+}
+
+//resetFlags sets back the flags of cmd and its subcommands to their
+//default value, flag values are kept between runs otherwise.
+func resetFlags(cmd *cobra.Command) {
+	cmd.Flags().VisitAll(func(flag *pflag.Flag) {
+		if slice, ok := flag.Value.(pflag.SliceValue); ok {
+			slice.Replace(nil)
+		} else {
+			flag.Value.Set(flag.DefValue)
+		}
+		flag.Changed = false
+	})
+	for _, subCmd := range cmd.Commands() {
+		resetFlags(subCmd)
+	}
+}
+
+//driveTree returns the content of the files of the fake Drive that aren't
+//trashed by path, folders end with a slash and have no content.
+func driveTree(s *fakedrive.Server) map[string]string {
+	files := make(map[string]fakedrive.File)
+	for _, f := range s.Files() {
+		files[f.ID] = f
+	}
+	tree := make(map[string]string)
+	for _, f := range s.Files() {
+		var names []string
+		for file, ok := f, true; ; file, ok = files[file.Parents[0]] {
+			if !ok || file.Trashed || len(file.Parents) == 0 {
+				names = nil
+				break
+			}
+			names = append([]string{file.Name}, names...)
+			if file.Parents[0] == fakedrive.RootID {
+				break
+			}
+		}
+		if names == nil {
+			continue
+		}
+		if f.MimeType == "application/vnd.google-apps.folder" {
+			tree[path.Join(names...)+"/"] = ""
+		} else {
+			tree[path.Join(names...)] = string(f.Content)
+		}
+	}
+	return tree
+}
+
+//checkTree checks the fake Drive holds the given files and folders only.
+func checkTree(t *testing.T, s *fakedrive.Server, want map[string]string) {
+	t.Helper()
+	if tree := driveTree(s); !reflect.DeepEqual(tree, want) {
+		t.Errorf("remote tree = %v, want %v", tree, want)
+	}
+}
+
+//checkState checks the sync state of file holds the Id of its remote copy
+//and, for files, its current hash and the remote head revision.
+func checkState(t *testing.T, s *fakedrive.Server, file string) {
+	t.Helper()
+	if folderId, err := ReadFolderId(file, DefaultRemote); err == nil {
+		if f, ok := s.Lookup(folderId); !ok || f.Name != path.Base(file) || f.Trashed {
+			t.Errorf("remote folder %v of %q = %+v", folderId, file, f)
+		}
+		return
+	}
+	hash, fileId, revision, err := ReadChkSum(file, DefaultRemote)
+	if err != nil {
+		t.Errorf("no sync state of %q: %v", file, err)
+		return
+	}
+	f, ok := s.Lookup(fileId)
+	if !ok || f.Name != path.Base(file) || f.Trashed {
+		t.Errorf("remote file %v of %q = %+v", fileId, file, f)
+	}
+	if hash != HashFile(file) || revision != f.HeadRevisionID {
+		t.Errorf("sync state of %q = %v %v, want %v %v", file, hash, revision, HashFile(file), f.HeadRevisionID)
+	}
+	if !ChkSumFile(file, DefaultRemote) {
+		t.Errorf("ChkSumFile(%q) = false after a sync", file)
+	}
+}
+
+func TestSyncerSync(t *testing.T) {
+	testHome(t)
+	s := startFakeDrive(t)
+	src := path.Join(t.TempDir(), "src")
+	writeFile(t, path.Join(src, "a.txt"), "a")
+	writeFile(t, path.Join(src, "sub", "b.txt"), "b")
+	syncer := &Syncer{Remote: DefaultRemote, Backend: GetBackend(GetRemote(DefaultRemote), false)}
+
+	//first upload
+	syncer.Sync(src)
+	checkTree(t, s, map[string]string{"src/": "", "src/a.txt": "a", "src/sub/": "", "src/sub/b.txt": "b"})
+	for _, file := range []string{src, path.Join(src, "a.txt"), path.Join(src, "sub"), path.Join(src, "sub", "b.txt")} {
+		checkState(t, s, file)
+	}
+	_, aId, _, _ := ReadChkSum(path.Join(src, "a.txt"), DefaultRemote)
+	a, _ := s.Lookup(aId)
+	files := len(s.Files())
+
+	//unchanged files are skipped
+	syncer.Sync(src)
+	if len(s.Files()) != files {
+		t.Errorf("unchanged sync created %v remote files", len(s.Files())-files)
+	}
+	if unchanged, _ := s.Lookup(aId); unchanged.HeadRevisionID != a.HeadRevisionID {
+		t.Errorf("unchanged file was uploaded again, revision %v, want %v", unchanged.HeadRevisionID, a.HeadRevisionID)
+	}
+
+	//modified files are updated in place
+	writeFile(t, path.Join(src, "a.txt"), "updated")
+	if ChkSumFile(path.Join(src, "a.txt"), DefaultRemote) {
+		t.Error("ChkSumFile of a modified file = true")
+	}
+	syncer.Sync(src)
+	checkTree(t, s, map[string]string{"src/": "", "src/a.txt": "updated", "src/sub/": "", "src/sub/b.txt": "b"})
+	checkState(t, s, path.Join(src, "a.txt"))
+	if _, fileId, _, _ := ReadChkSum(path.Join(src, "a.txt"), DefaultRemote); fileId != aId {
+		t.Errorf("updated file Id = %v, want %v", fileId, aId)
+	}
+	if updated, _ := s.Lookup(aId); updated.HeadRevisionID == a.HeadRevisionID {
+		t.Error("updated file kept its revision")
+	}
+
+	//new files go to the existing remote folders
+	writeFile(t, path.Join(src, "sub", "c.txt"), "c")
+	syncer.Sync(src)
+	checkTree(t, s, map[string]string{"src/": "", "src/a.txt": "updated", "src/sub/": "", "src/sub/b.txt": "b", "src/sub/c.txt": "c"})
+	checkState(t, s, path.Join(src, "sub", "c.txt"))
+	subId, _ := ReadFolderId(path.Join(src, "sub"), DefaultRemote)
+	_, cId, _, _ := ReadChkSum(path.Join(src, "sub", "c.txt"), DefaultRemote)
+	if c, _ := s.Lookup(cId); len(c.Parents) != 1 || c.Parents[0] != subId {
+		t.Errorf("parents of the new file = %v, want [%v]", c.Parents, subId)
+	}
+}
+
+func TestSyncerSyncFile(t *testing.T) {
+	testHome(t)
+	s := startFakeDrive(t)
+	file := path.Join(t.TempDir(), "single.txt")
+	writeFile(t, file, "single")
+	syncer := &Syncer{Remote: DefaultRemote, Backend: GetBackend(GetRemote(DefaultRemote), false)}
+
+	syncer.Sync(file)
+	checkTree(t, s, map[string]string{"single.txt": "single"})
+	checkState(t, s, file)
+	files := len(s.Files())
+	syncer.Sync(file)
+	if len(s.Files()) != files {
+		t.Errorf("unchanged sync created %v remote files", len(s.Files())-files)
+	}
+	writeFile(t, file, "updated")
+	syncer.Sync(file)
+	checkTree(t, s, map[string]string{"single.txt": "updated"})
+	checkState(t, s, file)
+}
+
+func TestSyncCommand(t *testing.T) {
+	testHome(t)
+	s := startFakeDrive(t)
+	src := path.Join(t.TempDir(), "src")
+	writeFile(t, path.Join(src, "a.txt"), "a")
+	writeFile(t, path.Join(src, "sub", "b.txt"), "b")
+
+	runDsync(t, "sync", src)
+	checkTree(t, s, map[string]string{"src/": "", "src/a.txt": "a", "src/sub/": "", "src/sub/b.txt": "b"})
+	checkState(t, s, path.Join(src, "sub", "b.txt"))
+	files := len(s.Files())
+	runDsync(t, "sync", src)
+	if len(s.Files()) != files {
+		t.Errorf("unchanged sync created %v remote files", len(s.Files())-files)
+	}
+
+	writeFile(t, path.Join(src, "sub", "b.txt"), "updated")
+	writeFile(t, path.Join(src, "sub", "c.txt"), "c")
+	runDsync(t, "sync", src)
+	checkTree(t, s, map[string]string{"src/": "", "src/a.txt": "a", "src/sub/": "", "src/sub/b.txt": "updated", "src/sub/c.txt": "c"})
+	checkState(t, s, path.Join(src, "sub", "b.txt"))
+	checkState(t, s, path.Join(src, "sub", "c.txt"))
+
+	//a dry run sends nothing
+	writeFile(t, path.Join(src, "d.txt"), "d")
+	runDsync(t, "sync", src, "--dry-run")
+	checkTree(t, s, map[string]string{"src/": "", "src/a.txt": "a", "src/sub/": "", "src/sub/b.txt": "updated", "src/sub/c.txt": "c"})
+}
+
+func TestDryRunCallsNoRemote(t *testing.T) {
+	testHome(t)
+	s := startFakeDrive(t)
+	src := path.Join(t.TempDir(), "src")
+	writeFile(t, path.Join(src, "a.txt"), "a")
+	writeFile(t, path.Join(src, "sub", "b.txt"), "b")
+	runDsync(t, "add", src, "--remote", "Backups/laptop", "--mirror", MirrorTrash, "--two-way")
+	runDsync(t, "all")
+
+	writeFile(t, path.Join(src, "c.txt"), "c")
+	writeFile(t, path.Join(src, "sub", "b.txt"), "updated")
+	if err := os.Rename(path.Join(src, "a.txt"), path.Join(src, "moved.txt")); err != nil {
+		t.Fatal(err)
+	}
+	requests := s.Requests()
+	runDsync(t, "all", "--dry-run")
+	runDsync(t, "sync", src, "--dry-run", "--remote", "Other/path", "--mirror", MirrorDelete)
+	runDsync(t, "sync", src, "--dry-run", "--snapshot")
+	if s.Requests() != requests {
+		t.Errorf("dry runs sent %v requests to the remote", s.Requests()-requests)
+	}
+}
+
+func TestAllCommand(t *testing.T) {
+	testHome(t)
+	s := startFakeDrive(t)
+	dir := t.TempDir()
+	src := path.Join(dir, "src")
+	writeFile(t, path.Join(src, "a.txt"), "a")
+	writeFile(t, path.Join(src, "skipped.log"), "log")
+	other := path.Join(dir, "other.txt")
+	writeFile(t, other, "other")
+
+	runDsync(t, "add", src, "--exclude", "*.log", "--remote", "Backups/laptop")
+	runDsync(t, "add", other)
+	runDsync(t, "all")
+	want := map[string]string{
+		"Backups/": "", "Backups/laptop/": "", "Backups/laptop/src/": "", "Backups/laptop/src/a.txt": "a",
+		"other.txt": "other",
+	}
+	checkTree(t, s, want)
+	checkState(t, s, path.Join(src, "a.txt"))
+	checkState(t, s, other)
+
+	files := len(s.Files())
+	runDsync(t, "all")
+	if len(s.Files()) != files {
+		t.Errorf("unchanged sync created %v remote files", len(s.Files())-files)
+	}
+	writeFile(t, other, "updated")
+	runDsync(t, "all")
+	want["other.txt"] = "updated"
+	checkTree(t, s, want)
+	checkState(t, s, other)
+}
+
+func TestSyncSharedDrive(t *testing.T) {
+	testHome(t)
+	s := startFakeDrive(t)
+	driveId := s.AddSharedDrive("Team")
+	file := path.Join(t.TempDir(), "shared.txt")
+	writeFile(t, file, "shared")
+
+	runDsync(t, "sync", file, "--shared-drive", "Team")
+	f, ok := s.Find(driveId, "shared.txt")
+	if !ok || string(f.Content) != "shared" {
+		t.Errorf("file in the shared drive = %+v, %v", f, ok)
+	}
+	if _, ok := s.Find(fakedrive.RootID, "shared.txt"); ok {
+		t.Error("file synced to a shared drive is in My Drive")
+	}
+}
+
+func TestDriveFullAccess(t *testing.T) {
+	testHome(t)
+	s := startFakeDrive(t)
+	s.AddSharedDrive("Team")
+	//the fake Drive ignores scopes, check the ones the backends ask for
+	if scope := driveScope(false); scope != drive.DriveFileScope {
+		t.Errorf("default scope = %v, want %v", scope, drive.DriveFileScope)
+	}
+	if scope := driveScope(true); scope != drive.DriveScope {
+		t.Errorf("full access scope = %v, want %v", scope, drive.DriveScope)
+	}
+
+	backends := make(map[string]Backend)
+	for _, test := range []struct {
+		task       Task
+		fullAccess bool
+	}{
+		{Task{Path: "/plain"}, false},
+		{Task{Path: "/shared", SharedDrive: "Team"}, true},
+		{Task{Path: "/two-way", TwoWay: true}, true},
+	} {
+		backend, ok := TaskBackend(test.task, DefaultRemote, backends).(*driveBackend)
+		if !ok || backend.fullAccess != test.fullAccess {
+			t.Errorf("backend of %+v = %+v, want full access %v", test.task, backend, test.fullAccess)
+		}
+	}
+
+	//backends limited to the files of dsync can't reach shared drives
+	sharedDriver := GetBackend(GetRemote(DefaultRemote), false).(SharedDriver)
+	if _, _, err := sharedDriver.SharedDrive("Team"); !errors.Is(err, errNoFullAccess) {
+		t.Errorf("SharedDrive without full access = %v, want %v", err, errNoFullAccess)
+	}
+}
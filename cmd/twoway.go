@@ -0,0 +1,358 @@
+/*
+Copyright © 2022 NAME HERE <EMAIL ADDRESS>
+
+*/
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+//Change is a change made to a remote file or folder.
+type Change struct {
+	ID string
+	//Removed is set when the file was deleted, File is nil then.
+	Removed bool
+	File    *RemoteFile
+}
+
+//Changer is implemented by backends able to list the changes made to remote
+//files, it enables two-way sync.
+type Changer interface {
+	//StartPageToken returns the token of the current state of the backend.
+	StartPageToken() (string, error)
+	//Changes returns the changes made since the given token and the token
+	//to use next time.
+	Changes(token string) ([]*Change, string, error)
+}
+
+//localEntry is a local file or folder synced to a remote Id.
+type localEntry struct {
+	path  string
+	isDir bool
+}
+
+//pull downloads the changes made to the remote copy of root since the last
+//sync. It returns the changes page token to save once root is pushed, or an
+//empty string if the backend can't list its changes.
+func (s *Syncer) pull(root string) string {
+	changer, ok := s.Backend.(Changer)
+	if !ok {
+		fmt.Printf("Remote %v can't list its changes, %q is only synced one way\n", s.Remote, root)
+		return ""
+	}
+	token, err := ReadChangesToken(root, s.Remote)
+	if errors.Is(err, os.ErrNotExist) {
+		//first two-way sync, remote changes are tracked from now on
+		token, err := changer.StartPageToken()
+		if err != nil {
+			log.Fatalf("Unable to get changes page token of %v: %v", s.Remote, err)
+		}
+		return token
+	}
+	changes, token, err := changer.Changes(token)
+	if err != nil {
+		log.Fatalf("Unable to list changes of %v: %v", s.Remote, err)
+	}
+	s.applyChanges(root, changes)
+	return token
+}
+
+//indexSynced returns the local files and folders synced inside root by
+//remote Id, including root itself.
+func (s *Syncer) indexSynced(root string) map[string]localEntry {
+	index := make(map[string]localEntry)
+	if folderId, err := ReadFolderId(root, s.Remote); err == nil {
+		index[folderId] = localEntry{path: root, isDir: true}
+	}
+	if _, fileId, _, err := ReadChkSum(root, s.Remote); err == nil {
+		index[fileId] = localEntry{path: root}
+	}
+	if info, err := os.Stat(root); err != nil || !info.IsDir() {
+		return index
+	}
+	walkState(root, s.Remote, s.ignore, func(source string, isFolder bool) {
+		if isFolder {
+			if folderId, err := ReadFolderId(source, s.Remote); err == nil {
+				index[folderId] = localEntry{path: source, isDir: true}
+			}
+		} else if _, fileId, _, err := ReadChkSum(source, s.Remote); err == nil {
+			index[fileId] = localEntry{path: source}
+		}
+	})
+	return index
+}
+
+//applyChanges applies remote changes to the local copy of root. Remote
+//edits are only downloaded over local files that weren't modified since
+//they were synced, the conflict policy applies to modified local files.
+func (s *Syncer) applyChanges(root string, changes []*Change) {
+	index := s.indexSynced(root)
+	rootId := ""
+	for id, entry := range index {
+		if entry.path == root {
+			rootId = id
+		}
+	}
+
+	//only the last change of every file matters
+	last := make(map[string]int)
+	for i, change := range changes {
+		last[change.ID] = i
+	}
+	var pending []*Change
+	for i, change := range changes {
+		if last[change.ID] == i {
+			pending = append(pending, change)
+		}
+	}
+
+	//new files can only be placed once their parent folder is, so changes
+	//are applied until none is left or none can be placed
+	for len(pending) > 0 {
+		var next []*Change
+		for _, change := range pending {
+			if !s.applyChange(root, rootId, index, change) {
+				next = append(next, change)
+			}
+		}
+		if len(next) == len(pending) {
+			//the rest is outside of the task
+			break
+		}
+		pending = next
+	}
+}
+
+//applyChange applies a remote change to the local files indexed by remote
+//Id. It returns false if the change is about a file whose parent folder is
+//unknown yet.
+func (s *Syncer) applyChange(root, rootId string, index map[string]localEntry, change *Change) bool {
+	entry, known := index[change.ID]
+	remoteFile := change.File
+	if change.Removed || remoteFile == nil || remoteFile.Trashed {
+		if known {
+			s.removeLocal(entry)
+			delete(index, change.ID)
+		}
+		return true
+	}
+
+	//local path of the remote file
+	target := root
+	if change.ID != rootId {
+		parent, ok := index[remoteFile.Parent]
+		if !ok || !parent.isDir {
+			if known {
+				//moved out of the task
+				s.removeLocal(entry)
+				delete(index, change.ID)
+				return true
+			}
+			return false
+		}
+		target = path.Join(parent.path, remoteFile.Name)
+	}
+
+	if !known {
+		if s.ignore.Ignored(target, remoteFile.IsDir) {
+			return true
+		}
+		if _, err := os.Lstat(target); err == nil {
+			fmt.Printf("%q was added to %v but exists locally and was kept\n", target, s.Remote)
+			return true
+		}
+		if remoteFile.IsDir {
+			if err := os.Mkdir(target, 0750); err != nil {
+				log.Fatalf("Unable to create dir %q: %v", target, err)
+			}
+			CreateFolderId(target, s.Remote, remoteFile.ID)
+			fmt.Printf("Created folder %q from %v\n", target, s.Remote)
+		} else if !s.pullFile(remoteFile, target) {
+			return true
+		}
+		index[change.ID] = localEntry{path: target, isDir: remoteFile.IsDir}
+		return true
+	}
+
+	if entry.path != target {
+		if !s.moveLocal(entry, target, index) {
+			return true
+		}
+		entry.path = target
+	}
+	if entry.isDir {
+		return true
+	}
+	_, _, revision, err := ReadChkSum(entry.path, s.Remote)
+	if err != nil {
+		log.Fatalf("Unable to read sync state of %q: %v", entry.path, err)
+	}
+	if remoteFile.Revision != "" && remoteFile.Revision == revision {
+		return true
+	}
+	if _, err := os.Lstat(entry.path); err == nil && !s.unchangedFile(entry.path) {
+		//modified both locally and in the remote, the conflict policy is
+		//applied when the file is pushed
+		return true
+	}
+	s.pullFile(remoteFile, entry.path)
+	return true
+}
+
+//pullFile downloads a remote file to file and writes its sync state. It
+//returns false if the file can't be downloaded.
+func (s *Syncer) pullFile(remoteFile *RemoteFile, file string) bool {
+	if remoteFile.LinkTarget != "" {
+		if err := createLink(file, remoteFile.LinkTarget); err != nil {
+			log.Fatalf("Unable to create symbolic link %q: %v", file, err)
+		}
+		writeChkSum(file, s.Remote, linkHash(remoteFile.LinkTarget), remoteFile)
+		fmt.Printf("Downloaded symbolic link %q from %v\n", file, s.Remote)
+		return true
+	}
+	if remoteFile.MD5 == "" {
+		//Google Docs files have no binary content
+		fmt.Printf("File %q in %v can't be downloaded and was skipped\n", file, s.Remote)
+		return false
+	}
+	if err := os.MkdirAll(path.Dir(file), 0750); err != nil {
+		log.Fatalf("Unable to create dir %q: %v", path.Dir(file), err)
+	}
+	hash, err := downloadFile(s.Backend, remoteFile, file)
+	if errors.Is(err, errChecksum) {
+		fmt.Printf("File %q doesn't match the remote MD5 checksum and wasn't downloaded\n", file)
+		return false
+	}
+	if err != nil {
+		log.Fatalf("Unable to download %q from %v: %v", file, s.Remote, err)
+	}
+	writeChkSum(file, s.Remote, hash, remoteFile)
+	fmt.Printf("Downloaded file %q from %v\n", file, s.Remote)
+	return true
+}
+
+//moveLocal renames or moves a local file or folder and its sync state like
+//its remote copy was. It returns false if target already exists.
+func (s *Syncer) moveLocal(entry localEntry, target string, index map[string]localEntry) bool {
+	if _, err := os.Lstat(target); err == nil {
+		fmt.Printf("%q was moved to %q in %v but it exists locally, the move was skipped\n", entry.path, target, s.Remote)
+		return false
+	}
+	if _, err := os.Lstat(entry.path); err == nil {
+		if err := os.Rename(entry.path, target); err != nil {
+			log.Fatalf("Unable to move %q to %q: %v", entry.path, target, err)
+		}
+	}
+	MoveState(entry.path, target, s.Remote)
+	if entry.isDir {
+		for id, child := range index {
+			if strings.HasPrefix(child.path, entry.path+"/") {
+				child.path = target + strings.TrimPrefix(child.path, entry.path)
+				index[id] = child
+			}
+		}
+	}
+	for id, moved := range index {
+		if moved.path == entry.path {
+			moved.path = target
+			index[id] = moved
+		}
+	}
+	fmt.Printf("Moved %q to %q like in %v\n", entry.path, target, s.Remote)
+	return true
+}
+
+//removeLocal removes a local file or folder whose remote copy was removed,
+//unless it holds local changes that weren't synced. Kept files and folders
+//lose their sync state so they are pushed again.
+func (s *Syncer) removeLocal(entry localEntry) {
+	if _, err := os.Lstat(entry.path); os.IsNotExist(err) {
+		return
+	}
+	if !entry.isDir {
+		if !s.unchangedFile(entry.path) {
+			fmt.Printf("File %q was removed from %v but modified locally, the local copy was kept\n", entry.path, s.Remote)
+			RemoveState(entry.path, s.Remote)
+			return
+		}
+		if err := os.Remove(entry.path); err != nil {
+			log.Fatalf("Unable to remove %q: %v", entry.path, err)
+		}
+		RemoveState(entry.path, s.Remote)
+		fmt.Printf("Removed file %q like in %v\n", entry.path, s.Remote)
+		return
+	}
+
+	if !s.unchangedDir(entry.path) {
+		fmt.Printf("Folder %q was removed from %v but holds local changes, the local copy was kept\n", entry.path, s.Remote)
+		RemoveState(entry.path, s.Remote)
+		return
+	}
+	if err := os.RemoveAll(entry.path); err != nil {
+		log.Fatalf("Unable to remove %q: %v", entry.path, err)
+	}
+	RemoveState(entry.path, s.Remote)
+	fmt.Printf("Removed folder %q like in %v\n", entry.path, s.Remote)
+}
+
+//unchangedDir checks every file inside dir is synced to the syncer remote
+//and wasn't modified since.
+func (s *Syncer) unchangedDir(dir string) bool {
+	unchanged := true
+	err := filepath.WalkDir(dir, func(file string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if entry.IsDir() {
+			return nil
+		}
+		if isSyncState(entry.Name()) {
+			//sync state of any remote
+			return nil
+		}
+		if !s.unchangedFile(file) {
+			unchanged = false
+			return filepath.SkipAll
+		}
+		return nil
+	})
+	if err != nil {
+		log.Fatalf("Unable to read dir %q: %v", dir, err)
+	}
+	return unchanged
+}
+
+//unchangedFile checks the file or symbolic link is synced to the syncer
+//remote and wasn't modified since. Links synced as links are compared by
+//target, followed links by the content of the file they point to.
+func (s *Syncer) unchangedFile(file string) bool {
+	fileStats, err := os.Lstat(file)
+	if err != nil {
+		log.Fatalf("Unable to get file %q stats: %v", file, err)
+	}
+	if fileStats.Mode()&fs.ModeSymlink != 0 {
+		target, err := os.Readlink(file)
+		if err != nil {
+			log.Fatalf("Unable to read symbolic link %q: %v", file, err)
+		}
+		hash, _, _, err := ReadChkSum(file, s.Remote)
+		if err != nil {
+			return false
+		}
+		if hash == linkHash(target) {
+			return true
+		}
+		if fileStats, err = os.Stat(file); err != nil {
+			return false
+		}
+	}
+	return fileStats.Mode().IsRegular() && ChkSumFile(file, s.Remote)
+}
@@ -0,0 +1,122 @@
+/*
+Copyright © 2022 NAME HERE <EMAIL ADDRESS>
+
+*/
+package cmd
+
+import (
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+//localTree returns the content of the files inside dir by path relative to
+//dir, folders end with a slash and have no content, symbolic links hold
+//"-> target".
+func localTree(t *testing.T, dir string) map[string]string {
+	t.Helper()
+	tree := make(map[string]string)
+	err := filepath.WalkDir(dir, func(file string, entry fs.DirEntry, err error) error {
+		if err != nil || file == dir {
+			return err
+		}
+		rel, _ := filepath.Rel(dir, file)
+		switch {
+		case entry.IsDir():
+			tree[rel+"/"] = ""
+		case entry.Type()&fs.ModeSymlink != 0:
+			target, err := os.Readlink(file)
+			if err != nil {
+				return err
+			}
+			tree[rel] = "-> " + target
+		default:
+			data, err := os.ReadFile(file)
+			if err != nil {
+				return err
+			}
+			tree[rel] = string(data)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tree
+}
+
+func TestTwoWaySync(t *testing.T) {
+	testHome(t)
+	s := startFakeDrive(t)
+	src := path.Join(t.TempDir(), "src")
+	writeFile(t, path.Join(src, "a.txt"), "a")
+	writeFile(t, path.Join(src, "b.txt"), "b")
+	writeFile(t, path.Join(src, "sub", "c.txt"), "c")
+	writeFile(t, path.Join(src, "old", "x.txt"), "x")
+	if err := os.Symlink("x.txt", path.Join(src, "old", "link")); err != nil {
+		t.Fatal(err)
+	}
+	runDsync(t, "add", src, "--two-way", "--symlinks", SymlinkLink)
+	runDsync(t, "all")
+	if _, err := ReadChangesToken(src, DefaultRemote); err != nil {
+		t.Fatalf("first two-way sync saved no changes token: %v", err)
+	}
+
+	//edited, moved, added and trashed in the remote
+	backend := GetBackend(GetRemote(DefaultRemote), true)
+	srcId, _ := ReadFolderId(src, DefaultRemote)
+	subId, _ := ReadFolderId(path.Join(src, "sub"), DefaultRemote)
+	oldId, _ := ReadFolderId(path.Join(src, "old"), DefaultRemote)
+	_, aId, _, _ := ReadChkSum(path.Join(src, "a.txt"), DefaultRemote)
+	_, bId, _, _ := ReadChkSum(path.Join(src, "b.txt"), DefaultRemote)
+	_, cId, _, _ := ReadChkSum(path.Join(src, "sub", "c.txt"), DefaultRemote)
+	if _, err := backend.Update(&RemoteFile{ID: aId}, strings.NewReader("remote a")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := backend.(Mover).Move(&RemoteFile{ID: bId, Name: "moved.txt", Parent: subId}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := backend.Upload(&RemoteFile{Name: "new.txt", Parent: srcId}, strings.NewReader("new")); err != nil {
+		t.Fatal(err)
+	}
+	for _, id := range []string{oldId, cId} {
+		if err := backend.(Trasher).Trash(id); err != nil {
+			t.Fatal(err)
+		}
+	}
+	//trashed in the remote but modified locally
+	writeFile(t, path.Join(src, "sub", "c.txt"), "local c")
+
+	runDsync(t, "all")
+	want := map[string]string{
+		"a.txt": "remote a", "new.txt": "new",
+		"sub/": "", "sub/moved.txt": "b", "sub/c.txt": "local c",
+	}
+	if tree := localTree(t, src); !reflect.DeepEqual(tree, want) {
+		t.Errorf("local tree = %v, want %v", tree, want)
+	}
+	checkTree(t, s, map[string]string{
+		"src/": "", "src/a.txt": "remote a", "src/new.txt": "new",
+		"src/sub/": "", "src/sub/moved.txt": "b", "src/sub/c.txt": "local c",
+	})
+	for _, file := range []string{"a.txt", "new.txt", "sub/moved.txt", "sub/c.txt"} {
+		checkState(t, s, path.Join(src, file))
+	}
+	if _, err := ReadState(path.Join(src, "old"), DefaultRemote); err == nil {
+		t.Error("sync state of the trashed folder kept")
+	}
+
+	//nothing left to pull or push
+	files := len(s.Files())
+	runDsync(t, "all")
+	if tree := localTree(t, src); !reflect.DeepEqual(tree, want) {
+		t.Errorf("local tree after the next sync = %v, want %v", tree, want)
+	}
+	if len(s.Files()) != files {
+		t.Errorf("next two-way sync created %v remote files", len(s.Files())-files)
+	}
+}
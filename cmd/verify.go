@@ -0,0 +1,244 @@
+/*
+Copyright © 2022 NAME HERE <EMAIL ADDRESS>
+
+*/
+package cmd
+
+import (
+	"crypto/md5"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+
+	"github.com/spf13/cobra"
+)
+
+//Verifier checks the remote copies of synced files against the local ones
+//using the remote sizes and MD5 checksums, nothing is downloaded.
+type Verifier struct {
+	Remote  string
+	Backend Backend
+
+	ignore     *Ignorer
+	symlinks   string
+	ok         int
+	missing    int
+	mismatched int
+	orphaned   int
+	modified   int
+	unsynced   int
+	//remote copies that couldn't be checked
+	failed int
+}
+
+//Verify checks the remote copy of a file or folder of the given task.
+func (v *Verifier) Verify(task Task, file string) {
+	v.ignore = NewIgnorer(task.Path, task.Exclude, task.Include)
+	v.symlinks = task.Symlinks
+	fileStats, err := os.Stat(file)
+	if err != nil {
+		log.Fatalf("Unable to get file or dir %q stats: %v", file, err)
+	}
+	if !fileStats.IsDir() {
+		_, fileId, _, err := ReadChkSum(file, v.Remote)
+		if err != nil {
+			v.unsynced++
+			fmt.Printf("NOT SYNCED  %q\n", file)
+			return
+		}
+		remoteFile, err := v.Backend.Stat(fileId)
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			v.failed++
+			fmt.Printf("FAILED      %q: %v\n", file, err)
+			return
+		}
+		if err != nil || remoteFile.Trashed {
+			v.missing++
+			fmt.Printf("MISSING     %q\n", file)
+			return
+		}
+		v.verifyFile(file, remoteFile)
+		return
+	}
+	folderId, err := ReadFolderId(file, v.Remote)
+	if err != nil {
+		v.unsynced++
+		fmt.Printf("NOT SYNCED  %q\n", file)
+		return
+	}
+	v.verifyDir(file, folderId)
+}
+
+//verifyDir lists the remote folder once and checks every synced file and
+//folder of dir against it, remote files no local file is synced to are
+//orphaned.
+func (v *Verifier) verifyDir(dir, folderId string) {
+	remoteFiles, err := v.Backend.List(folderId)
+	if err != nil {
+		log.Fatalf("Unable to list remote folder %q: %v", dir, err)
+	}
+	byId := make(map[string]*RemoteFile)
+	for _, remoteFile := range remoteFiles {
+		byId[remoteFile.ID] = remoteFile
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		log.Fatalf("Unable to read dir: %v", err)
+	}
+	for _, entry := range entries {
+		file := path.Join(dir, entry.Name())
+		fileType := entry.Type()
+		if fileType&fs.ModeSymlink != 0 && v.symlinks != SymlinkLink {
+			fileStats, err := os.Stat(file)
+			if v.symlinks == SymlinkSkip || err != nil {
+				//skipped or broken links aren't synced
+				continue
+			}
+			fileType = fileStats.Mode().Type()
+		}
+		isDir := fileType.IsDir()
+		if v.ignore.Ignored(file, isDir) || !isDir && !fileType.IsRegular() && fileType&fs.ModeSymlink == 0 {
+			continue
+		}
+		if isDir {
+			childId, err := ReadFolderId(file, v.Remote)
+			if err != nil {
+				v.unsynced++
+				fmt.Printf("NOT SYNCED  %q\n", file)
+				continue
+			}
+			if _, ok := byId[childId]; !ok {
+				v.missing++
+				fmt.Printf("MISSING     %q\n", file)
+				continue
+			}
+			delete(byId, childId)
+			v.verifyDir(file, childId)
+			continue
+		}
+		_, fileId, _, err := ReadChkSum(file, v.Remote)
+		if err != nil {
+			v.unsynced++
+			fmt.Printf("NOT SYNCED  %q\n", file)
+			continue
+		}
+		remoteFile, ok := byId[fileId]
+		if !ok {
+			v.missing++
+			fmt.Printf("MISSING     %q\n", file)
+			continue
+		}
+		delete(byId, fileId)
+		v.verifyFile(file, remoteFile)
+	}
+	for _, remoteFile := range remoteFiles {
+		if _, ok := byId[remoteFile.ID]; ok {
+			v.orphaned++
+			fmt.Printf("ORPHANED    %q Id %v\n", path.Join(dir, remoteFile.Name), remoteFile.ID)
+		}
+	}
+}
+
+//verifyFile checks a synced file against its remote copy.
+func (v *Verifier) verifyFile(file string, remoteFile *RemoteFile) {
+	if remoteFile.LinkTarget != "" {
+		if target, err := os.Readlink(file); err != nil || target != remoteFile.LinkTarget {
+			v.mismatched++
+			fmt.Printf("MISMATCHED  %q links to %q in %v\n", file, remoteFile.LinkTarget, v.Remote)
+			return
+		}
+		v.ok++
+		return
+	}
+	if !ChkSumFile(file, v.Remote) {
+		v.modified++
+		fmt.Printf("MODIFIED    %q since its last sync\n", file)
+		return
+	}
+	size, md5Sum := md5File(file)
+	switch {
+	case remoteFile.Size != size:
+		v.mismatched++
+		fmt.Printf("MISMATCHED  %q size is %v in %v, %v locally\n", file, remoteFile.Size, v.Remote, size)
+	case remoteFile.MD5 != "" && remoteFile.MD5 != md5Sum:
+		v.mismatched++
+		fmt.Printf("MISMATCHED  %q MD5 checksum is %v in %v, %v locally\n", file, remoteFile.MD5, v.Remote, md5Sum)
+	default:
+		v.ok++
+	}
+}
+
+//md5File returns the size and the hex encoded MD5 hash of the given file.
+func md5File(file string) (int64, string) {
+	f, err := os.Open(file)
+	if err != nil {
+		log.Fatalf("Unable to read file %q: %v\n", file, err)
+	}
+	defer f.Close()
+	md5Hash := md5.New()
+	size, err := io.Copy(md5Hash, f)
+	if err != nil {
+		log.Fatalf("Unable to read file %q: %v\n", file, err)
+	}
+	return size, fmt.Sprintf("%x", md5Hash.Sum(nil))
+}
+
+// verifyCmd represents the verify command
+var verifyCmd = &cobra.Command{
+	Use:   "verify [task]",
+	Short: "Verify the remote copies of synced files",
+	Long: `Verify the remote copies of a task or of all tasks:
+"dsync verify [task] [--from remote]"
+Every synced file is checked against the size and the MD5 checksum of its
+remote copy, listing every remote folder once and downloading nothing.
+Files whose remote copy is missing, doesn't match or can't be checked, and
+remote files no local file is synced to are reported.`,
+	Args: cobra.MaximumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		tasks := GetTasks()
+		var files []string
+		for _, task := range tasks {
+			files = append(files, task.Path)
+		}
+		if len(args) == 1 {
+			taskPath, err := filepath.Abs(args[0])
+			if err != nil {
+				log.Fatalf("Unable to get file or directory %q: %v", args[0], err)
+			}
+			tasks = []Task{FindTask(taskPath)}
+			files = []string{taskPath}
+		}
+		from, _ := cmd.Flags().GetString("from")
+
+		failed := 0
+		backends := make(map[string]Backend)
+		for i, task := range tasks {
+			remotes := task.Remotes()
+			if from != "" {
+				remotes = []string{from}
+			}
+			for _, remoteName := range remotes {
+				backend := TaskBackend(task, remoteName, backends)
+				verifier := &Verifier{Remote: remoteName, Backend: backend}
+				verifier.Verify(task, files[i])
+				fmt.Printf("Verified %q in %v: %v ok, %v missing, %v mismatched, %v orphaned, %v modified, %v not synced, %v failed\n",
+					files[i], remoteName, verifier.ok, verifier.missing, verifier.mismatched, verifier.orphaned, verifier.modified, verifier.unsynced, verifier.failed)
+				failed += verifier.missing + verifier.mismatched + verifier.failed
+			}
+		}
+		if failed > 0 {
+			log.Fatalf("%v files failed the verification", failed)
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(verifyCmd)
+
+	verifyCmd.Flags().StringP("from", "f", "", "Remote to verify (default all the task remotes)")
+}
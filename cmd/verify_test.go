@@ -0,0 +1,46 @@
+/*
+Copyright © 2022 NAME HERE <EMAIL ADDRESS>
+
+*/
+package cmd
+
+import (
+	"errors"
+	"os"
+	"path"
+	"testing"
+)
+
+//failingBackend is a Backend whose Stat calls fail with err.
+type failingBackend struct {
+	Backend
+	err error
+}
+
+func (b *failingBackend) Stat(id string) (*RemoteFile, error) {
+	return nil, b.err
+}
+
+func TestVerifyStatErrors(t *testing.T) {
+	testHome(t)
+	file := path.Join(t.TempDir(), "a.txt")
+	writeFile(t, file, "a")
+	backend := NewLocalBackend(t.TempDir())
+	(&Syncer{Remote: "local", Backend: backend}).Sync(file)
+
+	verifier := &Verifier{Remote: "local", Backend: backend}
+	verifier.Verify(Task{Path: file}, file)
+	if verifier.ok != 1 {
+		t.Errorf("verified %+v, want 1 ok", verifier)
+	}
+	verifier = &Verifier{Remote: "local", Backend: &failingBackend{backend, errors.New("503 Service Unavailable")}}
+	verifier.Verify(Task{Path: file}, file)
+	if verifier.failed != 1 || verifier.missing != 0 {
+		t.Errorf("verified %+v with a failing remote, want 1 failed", verifier)
+	}
+	verifier = &Verifier{Remote: "local", Backend: &failingBackend{backend, os.ErrNotExist}}
+	verifier.Verify(Task{Path: file}, file)
+	if verifier.missing != 1 || verifier.failed != 0 {
+		t.Errorf("verified %+v with a deleted remote copy, want 1 missing", verifier)
+	}
+}
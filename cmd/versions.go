@@ -0,0 +1,197 @@
+/*
+Copyright © 2022 NAME HERE <EMAIL ADDRESS>
+
+*/
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+//Revision is a past or current content of a remote file.
+type Revision struct {
+	ID      string
+	ModTime time.Time
+	Size    int64
+	MD5     string
+	//KeepForever is set for pinned revisions, the backend never purges them.
+	KeepForever bool
+}
+
+//Versioner is implemented by backends keeping the past contents of the
+//updated files.
+type Versioner interface {
+	//Revisions returns the revisions of the file with the given Id, oldest
+	//first, the last one is the current content.
+	Revisions(id string) ([]*Revision, error)
+	//DownloadRevision writes the content of a file revision to w.
+	DownloadRevision(id, revision string, w io.Writer) error
+	//KeepRevision pins or unpins a file revision.
+	KeepRevision(id, revision string, keep bool) error
+	//DeleteRevision removes a file revision, the current one can't be
+	//removed.
+	DeleteRevision(id, revision string) error
+}
+
+//timeLayouts are the accepted formats of the times given in the command
+//line, in local time unless a zone is given.
+var timeLayouts = []string{
+	time.RFC3339,
+	"2006-01-02T15:04:05",
+	"2006-01-02 15:04:05",
+	"2006-01-02T15:04",
+	"2006-01-02 15:04",
+	"2006-01-02",
+}
+
+//ParseTime parses a time given in the command line.
+func ParseTime(value string) (time.Time, error) {
+	//snapshot names are accepted as listed by the snapshots command
+	if t, err := time.Parse(SnapshotLayout, value); err == nil {
+		return t, nil
+	}
+	for _, layout := range timeLayouts {
+		if t, err := time.ParseInLocation(layout, value, time.Local); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid time %q, expected a time like \"2006-01-02 15:04\"", value)
+}
+
+//revisionAt returns the revision of a remote file that was current at the
+//given time, nil if there is none left, and the oldest revision kept.
+func revisionAt(v Versioner, id string, at time.Time) (current, oldest *Revision, err error) {
+	revisions, err := v.Revisions(id)
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, revision := range revisions {
+		if revision.ModTime.After(at) {
+			break
+		}
+		current = revision
+	}
+	if len(revisions) > 0 {
+		oldest = revisions[0]
+	}
+	return current, oldest, nil
+}
+
+//pruneRevisions deletes the revisions of a remote file but the keep newest
+//ones, the current one and the pinned ones. It returns the number of
+//deleted revisions.
+func pruneRevisions(v Versioner, id string, keep int) (int, error) {
+	revisions, err := v.Revisions(id)
+	if err != nil {
+		return 0, err
+	}
+	deleted := 0
+	for i, revision := range revisions {
+		if i >= len(revisions)-keep || i == len(revisions)-1 || revision.KeepForever {
+			continue
+		}
+		if err := v.DeleteRevision(id, revision.ID); err != nil {
+			return deleted, err
+		}
+		deleted++
+	}
+	return deleted, nil
+}
+
+// versionsCmd represents the versions command
+var versionsCmd = &cobra.Command{
+	Use:   "versions [file]",
+	Short: "List, pin and prune the revisions of a synced file",
+	Long: `List, pin and prune the revisions of a synced file:
+"dsync versions [file] [--from remote] [--pin rev...] [--unpin rev...] [--prune n]"
+Every update of a file keeps its previous content as a revision of the
+remote file. Pinned revisions are never purged by the remote nor pruned,
+"--prune" deletes all the revisions but the n newest ones. A past revision
+can be restored with "dsync restore [file] --at [time]".`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		file, err := filepath.Abs(args[0])
+		if err != nil {
+			log.Fatalf("Unable to get file %q: %v", args[0], err)
+		}
+		task := FindTask(file)
+		remoteName, _ := cmd.Flags().GetString("from")
+		if remoteName == "" {
+			remoteName = task.Remotes()[0]
+		}
+		backend := TaskBackend(task, remoteName, make(map[string]Backend))
+		versioner, ok := backend.(Versioner)
+		if !ok {
+			log.Fatalf("Remote %v doesn't keep file revisions", remoteName)
+		}
+		remoteFile, err := FindRemoteFile(backend, remoteName, task, file)
+		if err != nil {
+			log.Fatalf("Unable to find %q in %v: %v", file, remoteName, err)
+		}
+		if remoteFile.IsDir {
+			log.Fatalf("%q is a directory, only files have revisions", file)
+		}
+
+		pin, _ := cmd.Flags().GetStringSlice("pin")
+		unpin, _ := cmd.Flags().GetStringSlice("unpin")
+		for _, revision := range pin {
+			if err := versioner.KeepRevision(remoteFile.ID, revision, true); err != nil {
+				log.Fatalf("Unable to pin revision %v of %q: %v", revision, file, err)
+			}
+			fmt.Printf("Pinned revision %v of %q\n", revision, file)
+		}
+		for _, revision := range unpin {
+			if err := versioner.KeepRevision(remoteFile.ID, revision, false); err != nil {
+				log.Fatalf("Unable to unpin revision %v of %q: %v", revision, file, err)
+			}
+			fmt.Printf("Unpinned revision %v of %q\n", revision, file)
+		}
+		if cmd.Flags().Changed("prune") {
+			keep, _ := cmd.Flags().GetInt("prune")
+			if keep < 1 {
+				log.Fatalf("Invalid prune value %v, at least the current revision is kept", keep)
+			}
+			deleted, err := pruneRevisions(versioner, remoteFile.ID, keep)
+			if err != nil {
+				log.Fatalf("Unable to prune the revisions of %q: %v", file, err)
+			}
+			fmt.Printf("Pruned %v revisions of %q\n", deleted, file)
+		}
+
+		revisions, err := versioner.Revisions(remoteFile.ID)
+		if err != nil {
+			log.Fatalf("Unable to list the revisions of %q: %v", file, err)
+		}
+		fmt.Printf("Revisions of %q in %v:\n", file, remoteName)
+		for i, revision := range revisions {
+			var notes []string
+			if revision.KeepForever {
+				notes = append(notes, "pinned")
+			}
+			if i == len(revisions)-1 {
+				notes = append(notes, "current")
+			}
+			fmt.Printf("  %v  %v  %10v", revision.ID, revision.ModTime.Local().Format("2006-01-02 15:04:05"), formatSize(revision.Size))
+			if len(notes) > 0 {
+				fmt.Printf("  (%v)", strings.Join(notes, ", "))
+			}
+			fmt.Println()
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(versionsCmd)
+
+	versionsCmd.Flags().StringP("from", "f", "", "Remote holding the file (default the first task remote)")
+	versionsCmd.Flags().StringSlice("pin", nil, "Revisions to keep forever, can be repeated")
+	versionsCmd.Flags().StringSlice("unpin", nil, "Revisions to unpin, can be repeated")
+	versionsCmd.Flags().Int("prune", 0, "Delete all the revisions but the n newest ones and the pinned ones")
+}
@@ -0,0 +1,68 @@
+/*
+Copyright © 2022 NAME HERE <EMAIL ADDRESS>
+
+*/
+package cmd
+
+import (
+	"os"
+	"path"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRevisionAtPruned(t *testing.T) {
+	testHome(t)
+	startFakeDrive(t)
+	before := time.Now()
+	time.Sleep(10 * time.Millisecond)
+	src := path.Join(t.TempDir(), "src")
+	file := path.Join(src, "a.txt")
+	writeFile(t, file, "v1")
+	runDsync(t, "sync", src)
+	_, fileId, _, _ := ReadChkSum(file, DefaultRemote)
+	time.Sleep(10 * time.Millisecond)
+	at := time.Now()
+	time.Sleep(10 * time.Millisecond)
+	backend := GetBackend(GetRemote(DefaultRemote), false)
+	for _, content := range []string{"v2", "v3"} {
+		if _, err := backend.Update(&RemoteFile{ID: fileId}, strings.NewReader(content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	versioner := backend.(Versioner)
+	remoteFile, err := backend.Stat(fileId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if remoteFile.Created.IsZero() || remoteFile.Created.After(at) || remoteFile.Created.Before(before) {
+		t.Errorf("remote file created at %v, want between %v and %v", remoteFile.Created, before, at)
+	}
+	first, oldest, err := revisionAt(versioner, fileId, at)
+	if err != nil || first == nil || oldest == nil || first.ID != oldest.ID {
+		t.Fatalf("revisionAt = %+v, %+v, %v; want the first revision", first, oldest, err)
+	}
+
+	//not synced yet
+	if revision, _, _ := revisionAt(versioner, fileId, before); revision != nil {
+		t.Errorf("revision %+v found before the file was synced", revision)
+	}
+
+	//the revision of that time is pruned, the next one is the oldest left
+	if err := versioner.DeleteRevision(fileId, first.ID); err != nil {
+		t.Fatal(err)
+	}
+	revision, oldest, err := revisionAt(versioner, fileId, at)
+	if err != nil || revision != nil || oldest == nil || oldest.ID == first.ID {
+		t.Errorf("revisionAt after pruning = %+v, %+v, %v; want no revision and the second one as the oldest", revision, oldest, err)
+	}
+	if err := os.Remove(file); err != nil {
+		t.Fatal(err)
+	}
+	restorer := &Restorer{Remote: DefaultRemote, Backend: backend, At: at}
+	restorer.RestoreFile(remoteFile, file, file)
+	if _, err := os.Lstat(file); !os.IsNotExist(err) {
+		t.Errorf("a newer revision was restored in place of the pruned one: %v", err)
+	}
+}
@@ -0,0 +1,757 @@
+/*
+Copyright © 2022 NAME HERE <EMAIL ADDRESS>
+
+*/
+
+//Package fakedrive is an in-memory fake of the Google Drive v3 REST API, it
+//lets dsync sync, list and download files without network or a Google
+//account. Only the calls and search queries dsync uses are implemented.
+//
+//Point a drive remote to it with:
+//	dsync remote add drive --type drive --endpoint [server URL] --no-auth
+package fakedrive
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+//RootID is the Id of the My Drive root folder.
+const RootID = "root"
+
+const folderMimeType = "application/vnd.google-apps.folder"
+
+//File is a file or a folder stored in the fake Drive.
+type File struct {
+	ID             string            `json:"id"`
+	Name           string            `json:"name"`
+	MimeType       string            `json:"mimeType"`
+	Parents        []string          `json:"parents"`
+	Size           int64             `json:"size,string"`
+	MD5Checksum    string            `json:"md5Checksum,omitempty"`
+	CreatedTime    string            `json:"createdTime"`
+	ModifiedTime   string            `json:"modifiedTime"`
+	HeadRevisionID string            `json:"headRevisionId,omitempty"`
+	Trashed        bool              `json:"trashed"`
+	AppProperties  map[string]string `json:"appProperties,omitempty"`
+
+	Content []byte `json:"-"`
+	//Revisions holds the contents of the file, the last one is the head
+	//revision
+	Revisions []*Revision `json:"-"`
+}
+
+//Revision is a content of a file stored in the fake Drive.
+type Revision struct {
+	ID           string `json:"id"`
+	ModifiedTime string `json:"modifiedTime"`
+	Size         int64  `json:"size,string"`
+	MD5Checksum  string `json:"md5Checksum,omitempty"`
+	KeepForever  bool   `json:"keepForever"`
+
+	Content []byte `json:"-"`
+}
+
+//Server is a fake Drive listening on a local address.
+type Server struct {
+	*httptest.Server
+
+	mu    sync.Mutex
+	files map[string]*File
+	//drives holds the names of the shared drives by Id, their root
+	//folders are files with the same Id
+	drives   map[string]string
+	uploads  map[string]*upload
+	lastID   int
+	revision int
+	//changes holds the Ids of changed files, page tokens are positions
+	//in it
+	changes []string
+	//requests counts the requests served
+	requests int
+}
+
+//upload is a resumable upload in progress.
+type upload struct {
+	fileID string
+	meta   map[string]json.RawMessage
+	data   bytes.Buffer
+}
+
+//NewServer starts and returns a fake Drive, the caller should Close it.
+func NewServer() *Server {
+	s := &Server{
+		files:   make(map[string]*File),
+		drives:  make(map[string]string),
+		uploads: make(map[string]*upload),
+	}
+	s.files[RootID] = &File{ID: RootID, Name: "My Drive", MimeType: folderMimeType}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/drive/v3/files", s.handleFiles)
+	mux.HandleFunc("/drive/v3/files/", s.handleFile)
+	mux.HandleFunc("/upload/drive/v3/files", s.handleUpload)
+	mux.HandleFunc("/upload/drive/v3/files/", s.handleUpload)
+	mux.HandleFunc("/drive/v3/changes/startPageToken", s.handleStartPageToken)
+	mux.HandleFunc("/drive/v3/changes", s.handleChanges)
+	mux.HandleFunc("/drive/v3/drives", s.handleDrives)
+	mux.HandleFunc("/drive/v3/drives/", s.handleDrives)
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.mu.Lock()
+		s.requests++
+		s.mu.Unlock()
+		mux.ServeHTTP(w, r)
+	}))
+	return s
+}
+
+//Requests returns the number of requests served.
+func (s *Server) Requests() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.requests
+}
+
+//Lookup returns a copy of the file with the given Id.
+func (s *Server) Lookup(id string) (File, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	f, ok := s.files[id]
+	if !ok {
+		return File{}, false
+	}
+	return *f, true
+}
+
+//Find returns a copy of the not trashed file with the given name inside
+//the given parent folder.
+func (s *Server) Find(parent, name string) (File, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, f := range s.sortedFiles() {
+		if f.Name == name && !f.Trashed && hasParent(f, parent) {
+			return *f, true
+		}
+	}
+	return File{}, false
+}
+
+//Files returns a copy of all files but the root folder.
+func (s *Server) Files() []File {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var files []File
+	for _, f := range s.sortedFiles() {
+		files = append(files, *f)
+	}
+	return files
+}
+
+//AddSharedDrive adds a shared drive with the given name and returns its Id.
+func (s *Server) AddSharedDrive(name string) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.lastID++
+	id := fmt.Sprintf("drive%06d", s.lastID)
+	s.drives[id] = name
+	s.files[id] = &File{ID: id, Name: name, MimeType: folderMimeType}
+	return id
+}
+
+//handleDrives serves drives.list and drives.get.
+func (s *Server) handleDrives(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	type sharedDrive struct {
+		ID   string `json:"id"`
+		Name string `json:"name"`
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if id := strings.TrimPrefix(r.URL.Path, "/drive/v3/drives/"); id != r.URL.Path {
+		name, ok := s.drives[id]
+		if !ok {
+			writeError(w, http.StatusNotFound, "Shared drive not found: "+id)
+			return
+		}
+		writeJSON(w, sharedDrive{ID: id, Name: name})
+		return
+	}
+	list := struct {
+		Drives []sharedDrive `json:"drives"`
+	}{Drives: []sharedDrive{}}
+	for id, name := range s.drives {
+		list.Drives = append(list.Drives, sharedDrive{ID: id, Name: name})
+	}
+	sort.Slice(list.Drives, func(i, j int) bool { return list.Drives[i].ID < list.Drives[j].ID })
+	writeJSON(w, list)
+}
+
+//handleFiles serves files.list and files.create without media.
+func (s *Server) handleFiles(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		s.list(w, r)
+	case http.MethodPost:
+		var meta map[string]json.RawMessage
+		if err := json.NewDecoder(r.Body).Decode(&meta); err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		f, err := s.create(meta, nil)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		writeJSON(w, f)
+	default:
+		writeError(w, http.StatusMethodNotAllowed, r.Method)
+	}
+}
+
+//handleFile serves files.get, files.update without media and files.delete,
+//and the revisions calls.
+func (s *Server) handleFile(w http.ResponseWriter, r *http.Request) {
+	id, revisionPath, isRevision := strings.Cut(strings.TrimPrefix(r.URL.Path, "/drive/v3/files/"), "/revisions")
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	f, ok := s.files[id]
+	if !ok {
+		writeError(w, http.StatusNotFound, "File not found: "+id+".")
+		return
+	}
+	if isRevision {
+		s.handleRevision(w, r, f, strings.TrimPrefix(revisionPath, "/"))
+		return
+	}
+	switch r.Method {
+	case http.MethodGet:
+		if r.URL.Query().Get("alt") == "media" {
+			w.Header().Set("Content-Type", "application/octet-stream")
+			w.Write(f.Content)
+			return
+		}
+		writeJSON(w, f)
+	case http.MethodPatch:
+		var meta map[string]json.RawMessage
+		if err := json.NewDecoder(r.Body).Decode(&meta); err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		if err := s.update(f, meta, r, nil); err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		writeJSON(w, f)
+	case http.MethodDelete:
+		s.delete(id)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		writeError(w, http.StatusMethodNotAllowed, r.Method)
+	}
+}
+
+//handleRevision serves revisions.list, and revisions.get, update and delete
+//when revisionID is set.
+func (s *Server) handleRevision(w http.ResponseWriter, r *http.Request, f *File, revisionID string) {
+	if revisionID == "" {
+		if r.Method != http.MethodGet {
+			writeError(w, http.StatusMethodNotAllowed, r.Method)
+			return
+		}
+		writeJSON(w, map[string]interface{}{"revisions": append([]*Revision{}, f.Revisions...)})
+		return
+	}
+	index := -1
+	for i, revision := range f.Revisions {
+		if revision.ID == revisionID {
+			index = i
+		}
+	}
+	if index < 0 {
+		writeError(w, http.StatusNotFound, "Revision not found: "+revisionID+".")
+		return
+	}
+	revision := f.Revisions[index]
+	switch r.Method {
+	case http.MethodGet:
+		if r.URL.Query().Get("alt") == "media" {
+			w.Header().Set("Content-Type", "application/octet-stream")
+			w.Write(revision.Content)
+			return
+		}
+		writeJSON(w, revision)
+	case http.MethodPatch:
+		var meta map[string]json.RawMessage
+		if err := json.NewDecoder(r.Body).Decode(&meta); err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		if value, ok := meta["keepForever"]; ok {
+			if err := json.Unmarshal(value, &revision.KeepForever); err != nil {
+				writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid %q: %v", "keepForever", err))
+				return
+			}
+		}
+		writeJSON(w, revision)
+	case http.MethodDelete:
+		if index == len(f.Revisions)-1 {
+			writeError(w, http.StatusBadRequest, "The head revision can't be deleted.")
+			return
+		}
+		f.Revisions = append(f.Revisions[:index:index], f.Revisions[index+1:]...)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		writeError(w, http.StatusMethodNotAllowed, r.Method)
+	}
+}
+
+//handleUpload serves files.create and files.update with media, in the
+//media, multipart and resumable upload types.
+func (s *Server) handleUpload(w http.ResponseWriter, r *http.Request) {
+	id := strings.TrimPrefix(strings.TrimPrefix(r.URL.Path, "/upload/drive/v3/files"), "/")
+	query := r.URL.Query()
+	if uploadID := query.Get("upload_id"); uploadID != "" {
+		s.resumeUpload(w, r, uploadID)
+		return
+	}
+	meta := make(map[string]json.RawMessage)
+	var content []byte
+	switch query.Get("uploadType") {
+	case "media":
+		data, err := io.ReadAll(r.Body)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		content = data
+	case "multipart":
+		mediaType, params, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+		if err != nil || !strings.HasPrefix(mediaType, "multipart/") {
+			writeError(w, http.StatusBadRequest, "multipart body expected")
+			return
+		}
+		reader := multipart.NewReader(r.Body, params["boundary"])
+		metaPart, err := reader.NextPart()
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		if err := json.NewDecoder(metaPart).Decode(&meta); err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		mediaPart, err := reader.NextPart()
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		if content, err = io.ReadAll(mediaPart); err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+	case "resumable":
+		if err := json.NewDecoder(r.Body).Decode(&meta); err != nil && err != io.EOF {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		if _, ok := s.files[id]; id != "" && !ok {
+			writeError(w, http.StatusNotFound, "File not found: "+id+".")
+			return
+		}
+		s.lastID++
+		uploadID := strconv.Itoa(s.lastID)
+		s.uploads[uploadID] = &upload{fileID: id, meta: meta}
+		w.Header().Set("Location", fmt.Sprintf("%s/upload/drive/v3/files?%s&upload_id=%s", s.URL, query.Encode(), uploadID))
+		w.WriteHeader(http.StatusOK)
+		return
+	default:
+		writeError(w, http.StatusBadRequest, "unknown uploadType "+query.Get("uploadType"))
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.finishUpload(w, r, id, meta, content)
+}
+
+//resumeUpload receives a chunk of a resumable upload.
+func (s *Server) resumeUpload(w http.ResponseWriter, r *http.Request, uploadID string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	u, ok := s.uploads[uploadID]
+	if !ok {
+		writeError(w, http.StatusNotFound, "upload not found: "+uploadID)
+		return
+	}
+	if _, err := io.Copy(&u.data, r.Body); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	//the total size is only known in the last chunk
+	if strings.HasSuffix(r.Header.Get("Content-Range"), "/*") {
+		w.Header().Set("X-Http-Status-Code-Override", "308")
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	delete(s.uploads, uploadID)
+	s.finishUpload(w, r, u.fileID, u.meta, u.data.Bytes())
+}
+
+//finishUpload creates or updates a file with the uploaded content.
+func (s *Server) finishUpload(w http.ResponseWriter, r *http.Request, id string, meta map[string]json.RawMessage, content []byte) {
+	if content == nil {
+		content = []byte{}
+	}
+	if id == "" {
+		f, err := s.create(meta, content)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		writeJSON(w, f)
+		return
+	}
+	f, ok := s.files[id]
+	if !ok {
+		writeError(w, http.StatusNotFound, "File not found: "+id+".")
+		return
+	}
+	if err := s.update(f, meta, r, content); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	writeJSON(w, f)
+}
+
+//create adds a new file with the given metadata, content is nil for
+//files created without media.
+func (s *Server) create(meta map[string]json.RawMessage, content []byte) (*File, error) {
+	s.lastID++
+	f := &File{
+		ID:       fmt.Sprintf("fake%06d", s.lastID),
+		MimeType: "application/octet-stream",
+		Parents:  []string{RootID},
+	}
+	if err := setMeta(f, meta); err != nil {
+		return nil, err
+	}
+	for _, parent := range f.Parents {
+		if p, ok := s.files[parent]; !ok || p.MimeType != folderMimeType {
+			return nil, fmt.Errorf("parent folder %q not found", parent)
+		}
+	}
+	f.CreatedTime = time.Now().UTC().Format(time.RFC3339Nano)
+	if _, ok := meta["modifiedTime"]; !ok {
+		f.ModifiedTime = f.CreatedTime
+	}
+	if f.MimeType != folderMimeType {
+		s.setContent(f, content)
+	}
+	s.files[f.ID] = f
+	s.changes = append(s.changes, f.ID)
+	return f, nil
+}
+
+//update changes the metadata and, when not nil, the content of a file.
+func (s *Server) update(f *File, meta map[string]json.RawMessage, r *http.Request, content []byte) error {
+	if err := setMeta(f, meta); err != nil {
+		return err
+	}
+	query := r.URL.Query()
+	if removeParents := query.Get("removeParents"); removeParents != "" {
+		var parents []string
+		for _, parent := range f.Parents {
+			if !contains(strings.Split(removeParents, ","), parent) {
+				parents = append(parents, parent)
+			}
+		}
+		f.Parents = parents
+	}
+	if addParents := query.Get("addParents"); addParents != "" {
+		for _, parent := range strings.Split(addParents, ",") {
+			if _, ok := s.files[parent]; !ok {
+				return fmt.Errorf("parent folder %q not found", parent)
+			}
+			f.Parents = append(f.Parents, parent)
+		}
+	}
+	if content != nil {
+		s.setContent(f, content)
+	}
+	if _, ok := meta["modifiedTime"]; !ok {
+		f.ModifiedTime = time.Now().UTC().Format(time.RFC3339Nano)
+	}
+	s.changes = append(s.changes, f.ID)
+	return nil
+}
+
+//setContent stores the content of a file as a new head revision.
+func (s *Server) setContent(f *File, content []byte) {
+	s.revision++
+	f.Content = content
+	f.Size = int64(len(content))
+	f.MD5Checksum = fmt.Sprintf("%x", md5.Sum(content))
+	f.HeadRevisionID = fmt.Sprintf("rev%06d", s.revision)
+	f.Revisions = append(f.Revisions, &Revision{
+		ID:           f.HeadRevisionID,
+		ModifiedTime: time.Now().UTC().Format(time.RFC3339Nano),
+		Size:         f.Size,
+		MD5Checksum:  f.MD5Checksum,
+		Content:      content,
+	})
+}
+
+//delete removes a file and, for folders, all its children.
+func (s *Server) delete(id string) {
+	delete(s.files, id)
+	s.changes = append(s.changes, id)
+	for _, f := range s.sortedFiles() {
+		if hasParent(f, id) {
+			s.delete(f.ID)
+		}
+	}
+}
+
+//handleStartPageToken serves changes.getStartPageToken.
+func (s *Server) handleStartPageToken(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	writeJSON(w, map[string]string{"startPageToken": strconv.Itoa(len(s.changes) + 1)})
+}
+
+//handleChanges serves changes.list, removed files are listed without file.
+func (s *Server) handleChanges(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	start, err := strconv.Atoi(query.Get("pageToken"))
+	if err != nil || start < 1 {
+		writeError(w, http.StatusBadRequest, "invalid pageToken "+query.Get("pageToken"))
+		return
+	}
+	pageSize := 100
+	if size, err := strconv.Atoi(query.Get("pageSize")); err == nil && size > 0 {
+		pageSize = size
+	}
+
+	type change struct {
+		ChangeType string `json:"changeType"`
+		FileID     string `json:"fileId"`
+		Removed    bool   `json:"removed"`
+		File       *File  `json:"file,omitempty"`
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	page := struct {
+		NextPageToken     string   `json:"nextPageToken,omitempty"`
+		NewStartPageToken string   `json:"newStartPageToken,omitempty"`
+		Changes           []change `json:"changes"`
+	}{Changes: []change{}}
+	end := start - 1 + pageSize
+	if end < len(s.changes) {
+		page.NextPageToken = strconv.Itoa(end + 1)
+	} else {
+		end = len(s.changes)
+		page.NewStartPageToken = strconv.Itoa(end + 1)
+	}
+	for i := start - 1; i < end; i++ {
+		id := s.changes[i]
+		c := change{ChangeType: "file", FileID: id}
+		if f, ok := s.files[id]; ok {
+			c.File = f
+		} else {
+			c.Removed = true
+		}
+		page.Changes = append(page.Changes, c)
+	}
+	writeJSON(w, page)
+}
+
+//list serves files.list, search queries are a list of conditions joined by
+//"and" over parents, name, mimeType and trashed.
+func (s *Server) list(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	var conditions []func(*File) bool
+	if q := strings.TrimSpace(query.Get("q")); q != "" {
+		for _, term := range strings.Split(q, " and ") {
+			condition, err := parseCondition(strings.TrimSpace(term))
+			if err != nil {
+				writeError(w, http.StatusBadRequest, err.Error())
+				return
+			}
+			conditions = append(conditions, condition)
+		}
+	}
+	pageSize := 100
+	if size, err := strconv.Atoi(query.Get("pageSize")); err == nil && size > 0 {
+		pageSize = size
+	}
+	offset, _ := strconv.Atoi(query.Get("pageToken"))
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if query.Get("corpora") == "drive" {
+		if _, ok := s.drives[query.Get("driveId")]; !ok {
+			writeError(w, http.StatusNotFound, "Shared drive not found: "+query.Get("driveId"))
+			return
+		}
+	}
+	var matched []*File
+	for _, f := range s.sortedFiles() {
+		ok := true
+		for _, condition := range conditions {
+			ok = ok && condition(f)
+		}
+		if ok {
+			matched = append(matched, f)
+		}
+	}
+	page := struct {
+		NextPageToken string  `json:"nextPageToken,omitempty"`
+		Files         []*File `json:"files"`
+	}{Files: []*File{}}
+	if offset < len(matched) {
+		end := offset + pageSize
+		if end < len(matched) {
+			page.NextPageToken = strconv.Itoa(end)
+		} else {
+			end = len(matched)
+		}
+		page.Files = matched[offset:end]
+	}
+	writeJSON(w, page)
+}
+
+//parseCondition parses a single search query condition.
+func parseCondition(term string) (func(*File) bool, error) {
+	switch {
+	case strings.HasSuffix(term, " in parents"):
+		parent, err := unquote(strings.TrimSuffix(term, " in parents"))
+		if err != nil {
+			return nil, err
+		}
+		return func(f *File) bool { return hasParent(f, parent) }, nil
+	case term == "trashed = false" || term == "trashed = true":
+		trashed := term == "trashed = true"
+		return func(f *File) bool { return f.Trashed == trashed }, nil
+	case strings.HasPrefix(term, "name = "):
+		name, err := unquote(strings.TrimPrefix(term, "name = "))
+		if err != nil {
+			return nil, err
+		}
+		return func(f *File) bool { return f.Name == name }, nil
+	case strings.HasPrefix(term, "mimeType = "), strings.HasPrefix(term, "mimeType != "):
+		equal := strings.HasPrefix(term, "mimeType = ")
+		mimeType, err := unquote(term[strings.Index(term, "= ")+2:])
+		if err != nil {
+			return nil, err
+		}
+		return func(f *File) bool { return (f.MimeType == mimeType) == equal }, nil
+	}
+	return nil, fmt.Errorf("unsupported search query %q", term)
+}
+
+//setMeta sets the writable fields present in meta.
+func setMeta(f *File, meta map[string]json.RawMessage) error {
+	fields := map[string]interface{}{
+		"name":         &f.Name,
+		"mimeType":     &f.MimeType,
+		"parents":      &f.Parents,
+		"modifiedTime": &f.ModifiedTime,
+		"trashed":      &f.Trashed,
+	}
+	if value, ok := meta["appProperties"]; ok {
+		//properties are merged, null values remove a property
+		var properties map[string]*string
+		if err := json.Unmarshal(value, &properties); err != nil {
+			return fmt.Errorf("invalid %q: %v", "appProperties", err)
+		}
+		for key, property := range properties {
+			if f.AppProperties == nil {
+				f.AppProperties = make(map[string]string)
+			}
+			if property == nil {
+				delete(f.AppProperties, key)
+			} else {
+				f.AppProperties[key] = *property
+			}
+		}
+	}
+	for key, value := range meta {
+		field, ok := fields[key]
+		if !ok {
+			continue
+		}
+		if err := json.Unmarshal(value, field); err != nil {
+			return fmt.Errorf("invalid %q: %v", key, err)
+		}
+	}
+	return nil
+}
+
+//sortedFiles returns all files but the root folders in creation order.
+func (s *Server) sortedFiles() []*File {
+	var files []*File
+	for _, f := range s.files {
+		if _, ok := s.drives[f.ID]; f.ID != RootID && !ok {
+			files = append(files, f)
+		}
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].ID < files[j].ID })
+	return files
+}
+
+func hasParent(f *File, parent string) bool {
+	return contains(f.Parents, parent)
+}
+
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+//unquote parses a single quoted query string.
+func unquote(s string) (string, error) {
+	if len(s) < 2 || s[0] != '\'' || s[len(s)-1] != '\'' {
+		return "", fmt.Errorf("invalid query string %s", s)
+	}
+	s = s[1 : len(s)-1]
+	s = strings.ReplaceAll(s, `\'`, `'`)
+	return strings.ReplaceAll(s, `\\`, `\`), nil
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
+
+//writeError writes an error in the Google API error format.
+func writeError(w http.ResponseWriter, code int, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"error": map[string]interface{}{
+			"code":    code,
+			"message": message,
+		},
+	})
+}